@@ -1,4 +1,15 @@
 class ApplicationController < ActionController::Base
   # Only allow modern browsers supporting webp images, web push, badges, import maps, CSS nesting, and CSS :has.
   allow_browser versions: :modern
+
+  private
+
+  def append_info_to_payload(payload)
+    super
+    payload[:remote_ip] = request.remote_ip
+  end
+
+  def render_error(messages, status)
+    render json: { errors: Array(messages) }, status: status
+  end
 end
@@ -0,0 +1,19 @@
+module AdminTokenAuthentication
+  extend ActiveSupport::Concern
+
+  # admin endpoints are disabled unless a token is configured
+  ADMIN_TOKEN = ENV["CACHEMBED_ADMIN_TOKEN"]
+
+  included do
+    before_action :require_admin_token
+  end
+
+  private
+
+  def require_admin_token
+    return render_error("Not found", :not_found) if ADMIN_TOKEN.blank?
+
+    token = request.headers["Authorization"]&.split(" ")&.last.to_s
+    render_error("Unauthorized", :unauthorized) unless ActiveSupport::SecurityUtils.secure_compare(token, ADMIN_TOKEN)
+  end
+end
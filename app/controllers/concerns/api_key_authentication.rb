@@ -0,0 +1,17 @@
+module ApiKeyAuthentication
+  extend ActiveSupport::Concern
+
+  included do
+    before_action :require_api_key
+  end
+
+  private
+
+  def require_api_key
+    render_error("Unauthorized", :unauthorized) unless api_key.present?
+  end
+
+  def api_key
+    request.headers["Authorization"]&.split(" ")&.last
+  end
+end
@@ -0,0 +1,27 @@
+module TenantPartitioning
+  extend ActiveSupport::Concern
+
+  # e.g. X-Tenant-Id; when set, every request must carry it and the cache is partitioned by its value
+  TENANT_HEADER = ENV["CACHEMBED_TENANT_HEADER"].presence
+  MAX_TENANT_LENGTH = 128
+
+  included do
+    before_action :require_tenant
+  end
+
+  private
+
+  def require_tenant
+    return if TENANT_HEADER.nil?
+
+    if tenant.blank?
+      render_error("#{TENANT_HEADER} header is required", :bad_request)
+    elsif tenant.length > MAX_TENANT_LENGTH
+      render_error("#{TENANT_HEADER} header must be at most #{MAX_TENANT_LENGTH} characters", :bad_request)
+    end
+  end
+
+  def tenant
+    TENANT_HEADER ? request.headers[TENANT_HEADER].to_s : ""
+  end
+end
@@ -0,0 +1,20 @@
+class V1::Cache::ExistsController < ApplicationController
+  include ApiKeyAuthentication
+  include TenantPartitioning
+
+  skip_before_action :verify_authenticity_token
+
+  rescue_from EmbeddingTarget::InvalidInputError do |e|
+    render_error(e.message, :bad_request)
+  end
+  rescue_from ActiveRecord::RecordInvalid do |e|
+    render_error(e.record.errors.full_messages, :unprocessable_entity)
+  end
+
+  def create
+    existence = CacheExistence.new(params.permit(:model, :dimensions).to_h.symbolize_keys.merge(api_key: api_key, inputs: params[:inputs], tenant: tenant))
+    raise ActiveRecord::RecordInvalid.new(existence) unless existence.valid?
+
+    render json: { object: "list", cached: existence.results, model: existence.model }
+  end
+end
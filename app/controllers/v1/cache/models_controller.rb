@@ -0,0 +1,19 @@
+class V1::Cache::ModelsController < ApplicationController
+  include AdminTokenAuthentication
+
+  def index
+    rows = VectorCache.group(:model, :dimensions).order(:model, :dimensions)
+      .pluck(:model, :dimensions, Arel.sql("COUNT(*)"), Arel.sql("SUM(LENGTH(content))"))
+
+    data = rows.group_by(&:first).map do |model, model_rows|
+      {
+        model: model,
+        entries: model_rows.sum { |row| row[2] },
+        bytes: model_rows.sum { |row| row[3].to_i },
+        dimensions: model_rows.map { |_, dimensions, entries, bytes| { dimensions: dimensions, entries: entries, bytes: bytes.to_i } }
+      }
+    end
+
+    render json: { object: "list", data: data }
+  end
+end
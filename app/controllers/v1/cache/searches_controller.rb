@@ -0,0 +1,28 @@
+class V1::Cache::SearchesController < ApplicationController
+  include ApiKeyAuthentication
+  include TenantPartitioning
+
+  skip_before_action :verify_authenticity_token
+  prepend_before_action :require_search_enabled
+
+  rescue_from ActiveRecord::RecordInvalid do |e|
+    render_error(e.record.errors.full_messages, :unprocessable_entity)
+  end
+
+  def create
+    search = VectorSearch.new(search_params.merge(api_key: api_key, tenant: tenant))
+    raise ActiveRecord::RecordInvalid.new(search) unless search.valid?
+
+    render json: { object: "list", data: search.results, model: search.model }
+  end
+
+  private
+
+  def search_params
+    params.permit(:model, :top_k, embedding: []).to_h.symbolize_keys
+  end
+
+  def require_search_enabled
+    render_error("Not found", :not_found) unless VectorSearch::ENABLED
+  end
+end
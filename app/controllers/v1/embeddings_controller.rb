@@ -1,11 +1,32 @@
 class V1::EmbeddingsController < ApplicationController
+  include ApiKeyAuthentication
+  include TenantPartitioning
+
   skip_before_action :verify_authenticity_token
-  before_action :require_api_key
+  around_action :replay_idempotent_response
+  skip_before_action :require_api_key, :require_tenant, only: [ :allow, :method_not_allowed ]
+  skip_around_action :replay_idempotent_response, only: [ :allow, :method_not_allowed ]
+
+  ALLOWED_METHODS = "POST, OPTIONS"
+
+  IDEMPOTENCY_KEY_TTL = ENV.fetch("CACHEMBED_IDEMPOTENCY_KEY_TTL", "0").to_i.seconds
 
-  rescue_from EmbeddingTarget::InvalidInputError, with: -> { render_error("Invalid input", :bad_request) }
+  rescue_from EmbeddingTarget::InvalidInputError do |e|
+    render_error(e.message, :bad_request)
+  end
+  rescue_from UpstreamClient::UpstreamError do |e|
+    render json: { error: e.to_hash }, status: e.status
+  end
+  rescue_from UpstreamResponse::InvalidResponseError do |e|
+    render_error(e.message, :bad_gateway)
+  end
   rescue_from ActiveRecord::RecordInvalid do |e|
     render_error(e.record.errors.full_messages, :unprocessable_entity)
   end
+  rescue_from ActiveRecord::ConnectionNotEstablished, ActiveRecord::StatementInvalid do |e|
+    Rails.logger.error("Cache database error: #{e.message}")
+    render_error("Cache database is unavailable", :service_unavailable)
+  end
 
   def create
     form = EmbeddingForm.new(create_params)
@@ -13,12 +34,35 @@ class V1::EmbeddingsController < ApplicationController
     @model = form.model
     @prompt_tokens = form.prompt_tokens
     @total_tokens = form.total_tokens
+    @cache_hits = form.cache_hits
+    response.headers["X-Cachembed-Would-Have-Cost-Tokens"] = form.would_have_cost_tokens.to_s
+    response.headers["X-Cachembed-Store-Error"] = "true" if form.store_failed?
+    @cache_misses = form.cache_misses
+  end
+
+  # OPTIONS
+  def allow
+    response.headers["Allow"] = ALLOWED_METHODS
+    head :no_content
+  end
+
+  # any other method, including HEAD
+  def method_not_allowed
+    response.headers["Allow"] = ALLOWED_METHODS
+    # OpenAI-shaped, like relayed upstream errors, since only SDKs reach this path with the wrong method
+    render json: { error: { message: "Method not allowed", type: "invalid_request_error", param: nil, code: "method_not_allowed" } }, status: :method_not_allowed
   end
 
   private
 
+  def append_info_to_payload(payload)
+    super
+    payload[:cache_hits] = @cache_hits
+    payload[:cache_misses] = @cache_misses
+  end
+
   def create_params
-    embedding_params.permit(:model, :dimensions, :encoding_format).merge(api_key: api_key, input: input_param)
+    embedding_params.permit(:model, :dimensions, :encoding_format).merge(api_key: api_key, input: input_param, expires_in: request.headers["X-Cachembed-Expires-In"], tenant: tenant)
   end
 
   def embedding_params
@@ -34,15 +78,55 @@ class V1::EmbeddingsController < ApplicationController
     embedding_params[:input]
   end
 
-  def require_api_key
-    render_error("Unauthorized", :unauthorized) unless api_key.present?
+  # Repeated requests with the same Idempotency-Key (per API key and tenant) within the TTL
+  # get the stored response instead of calling upstream again. Reusing a key with another
+  # request body is refused.
+  def replay_idempotent_response
+    return yield if IDEMPOTENCY_KEY_TTL.zero? || request.headers["Idempotency-Key"].blank?
+
+    stored = find_idempotent_response
+    if stored&.request_digest == request_digest
+      stored.headers.to_h.each { |name, value| response.headers[name] = value }
+      render json: stored.body, status: stored.status
+      return
+    elsif stored
+      render_error("Idempotency-Key was already used with a different request body", :unprocessable_entity)
+      return
+    end
+
+    yield
+    store_idempotent_response
+  end
+
+  # with CACHEMBED_DB_FAILURE_MODE=fail-open a database outage skips idempotency instead of failing the request
+  def find_idempotent_response
+    IdempotentResponse.unexpired.find_by(key_digest: idempotency_key_digest)
+  rescue ActiveRecord::ConnectionNotEstablished, ActiveRecord::StatementInvalid => e
+    raise unless EmbeddingForm::DB_FAILURE_MODE == "fail-open"
+
+    Rails.logger.warn("Skipping idempotency lookup: #{e.message}")
+    nil
+  end
+
+  # the response is already rendered, so a failed store is only logged
+  def store_idempotent_response
+    IdempotentResponse.store!(
+      key_digest: idempotency_key_digest,
+      request_digest: request_digest,
+      status: response.status,
+      body: response.body,
+      headers: response.headers,
+      expires_at: IDEMPOTENCY_KEY_TTL.from_now
+    )
+  rescue ActiveRecord::ActiveRecordError => e
+    Rails.logger.warn("Failed to store the response for Idempotency-Key: #{e.message}")
   end
 
-  def api_key
-    request.headers["Authorization"]&.split(" ")&.last
+  def idempotency_key_digest
+    IdempotentResponse.digest(api_key, tenant, request.headers["Idempotency-Key"])
   end
 
-  def render_error(messages, status)
-    render json: { errors: Array(messages) }, status: status
+  def request_digest
+    IdempotentResponse.digest(request.raw_post)
   end
 end
@@ -0,0 +1,7 @@
+class V1::VersionsController < ApplicationController
+  include AdminTokenAuthentication
+
+  def show
+    render json: BuildInfo.new.to_h
+  end
+end
@@ -1,3 +1,20 @@
 class ApplicationRecord < ActiveRecord::Base
   primary_abstract_class
+
+  READ_REPLICA = configurations.configs_for(env_name: Rails.env, name: "primary_replica").present?
+
+  connects_to database: { writing: :primary, reading: :primary_replica } if READ_REPLICA
+
+  # Runs read-only queries on the replica when one is configured, falling back
+  # to the primary when the replica is unreachable. Load relations inside the block.
+  def self.reading_from_replica(&block)
+    return yield unless READ_REPLICA
+
+    begin
+      ApplicationRecord.connected_to(role: :reading, &block)
+    rescue ActiveRecord::ConnectionNotEstablished => e
+      Rails.logger.warn("Read replica unavailable, reading from primary: #{e.message}")
+      yield
+    end
+  end
 end
@@ -0,0 +1,23 @@
+# What an instance runs: the revision it was built from, and the database it uses.
+class BuildInfo
+  # set by `docker build --build-arg CACHEMBED_REVISION=$(git rev-parse HEAD)`;
+  # deploys that copy the source tree may write a REVISION file instead
+  REVISION = ENV["CACHEMBED_REVISION"].presence || Rails.root.join("REVISION").then { |path| path.read.strip if path.exist? }.presence
+
+  def to_h
+    {
+      revision: REVISION,
+      ruby_version: RUBY_VERSION,
+      rails_version: Rails.version,
+      adapter: ActiveRecord::Base.connection_db_config.adapter,
+      schema_version: migration_context.current_version,
+      migrations_current: !migration_context.needs_migration?
+    }
+  end
+
+  private
+
+  def migration_context
+    ActiveRecord::Base.connection_pool.migration_context
+  end
+end
@@ -0,0 +1,95 @@
+# Deletes cache entries created, or last accessed, before a threshold. Each batch
+# locks the next BATCH_SIZE stale ids in primary-key order and deletes those still stale,
+# so batches never scan id ranges that earlier runs have already emptied.
+class CacheCollector
+  DURATION_FORMAT = /\A(\d+)([smhdw])\z/
+  DURATION_UNITS = { "s" => 1.second, "m" => 1.minute, "h" => 1.hour, "d" => 1.day, "w" => 1.week }.freeze
+
+  # a zero or negative BEFORE would put the threshold in the future and delete everything
+  def self.parse_duration(value)
+    match = DURATION_FORMAT.match(value.to_s)
+    raise ArgumentError, "BEFORE must be a positive number followed by s, m, h, d or w (e.g. 30d), got #{value.inspect}" if match.nil? || match[1].to_i.zero?
+
+    match[1].to_i * DURATION_UNITS.fetch(match[2])
+  end
+
+  # FIELD=accessed evicts entries nobody asked for recently; created evicts by age regardless of use
+  FIELDS = { "created" => :created_at, "accessed" => :last_accessed_at }.freeze
+
+  def initialize(before:, field: "created", batch_size: 1000, sleep_seconds: 0, start_id: 0, max_duration: nil, io: $stdout, sleeper: ->(seconds) { sleep(seconds) })
+    raise ArgumentError, "START_ID must not be negative, got #{start_id}" if start_id.negative?
+    raise ArgumentError, "FIELD must be one of #{FIELDS.keys.join(", ")}, got #{field}" unless FIELDS.key?(field)
+
+    @column = FIELDS.fetch(field)
+    @threshold = before.ago
+    @batch_size = batch_size
+    @sleep_seconds = sleep_seconds
+    @start_id = start_id
+    @max_duration = max_duration
+    @io = io
+    @sleeper = sleeper
+  end
+
+  # finishes the current batch, then stops; safe to call from a signal handler
+  def stop!
+    @stop_requested = true
+  end
+
+  # true when the last run ended on MAX_DURATION or stop! before reaching the last stale entry
+  def stopped_early?
+    @stopped_early == true
+  end
+
+  # returns the number of deleted entries
+  def run
+    deleted = 0
+    @stopped_early = false
+    next_id = @start_id
+    started_at = monotonic_now
+    loop do
+      ids, count = delete_batch(next_id)
+      break if ids.empty?
+
+      deleted += count
+      next_id = ids.last + 1
+      @io.puts "deleted #{deleted} entries up to id #{ids.last} (#{rows_per_second(deleted, started_at)} rows/sec)"
+      if stopping?(started_at)
+        @io.puts "stopped early after #{deleted} entries, last processed id #{ids.last}; resume with START_ID=#{next_id}"
+        @stopped_early = true
+        return deleted
+      end
+      @sleeper.call(@sleep_seconds) if count.positive? && @sleep_seconds.positive?
+    end
+    @io.puts "deleted #{deleted} entries with #{@column} before #{@threshold.iso8601}"
+    deleted
+  end
+
+  private
+
+  # Locks the batch in id order, skipping rows a cache hit is updating, so a hit's
+  # multi-row update and the delete never wait on each other. Skipped rows are left
+  # for the next run. SQLite ignores the lock clause; it has a single writer anyway.
+  def delete_batch(from_id)
+    VectorCache.transaction do
+      ids = stale_entries.where(id: from_id..).order(:id).limit(@batch_size).lock("FOR UPDATE SKIP LOCKED").pluck(:id)
+      [ ids, ids.empty? ? 0 : stale_entries.where(id: ids).delete_all ]
+    end
+  end
+
+  def stale_entries
+    VectorCache.where(@column => ...@threshold)
+  end
+
+  def stopping?(started_at)
+    @stop_requested || (@max_duration.present? && monotonic_now - started_at >= @max_duration)
+  end
+
+  def rows_per_second(deleted, started_at)
+    elapsed = monotonic_now - started_at
+    elapsed.positive? ? (deleted / elapsed).round : deleted
+  end
+
+  def monotonic_now
+    Process.clock_gettime(Process::CLOCK_MONOTONIC)
+  end
+end
@@ -0,0 +1,44 @@
+# Deletes every cache entry and request log derived from a given text, hashed
+# exactly as incoming requests are.
+class CacheEraser
+  ALL_MODELS = "*"
+  DELETE_BATCH_SIZE = 1000
+
+  Result = Struct.new(:found, :deleted, keyword_init: true)
+
+  # tenant: nil deletes entries of every tenant; request logs are not partitioned by tenant
+  def initialize(model: ALL_MODELS, match_substring: false, tenant: nil)
+    @model = model
+    @match_substring = match_substring
+    @tenant = tenant
+  end
+
+  def erase(text)
+    ActiveRecord::Base.transaction do
+      vectors = vector_caches_for(text)
+      # with match_substring the logs of every matched input go too, not only the exact text's
+      input_hashes = vectors.distinct.pluck(:input_hash) | [ EmbeddingTarget.new(text).sha1sum ]
+      found = vectors.count
+      deleted = vectors.delete_all
+      input_hashes.each_slice(DELETE_BATCH_SIZE) { |batch| embedding_requests_for(batch).delete_all }
+      Result.new(found: found, deleted: deleted)
+    end
+  end
+
+  private
+
+  def vector_caches_for(text)
+    relation = if @match_substring
+      VectorCache.where("input_text LIKE ?", "%#{VectorCache.sanitize_sql_like(text)}%")
+    else
+      VectorCache.where(input_hash: EmbeddingTarget.new(text).sha1sum)
+    end
+    relation = relation.where(tenant: @tenant) unless @tenant.nil?
+    @model == ALL_MODELS ? relation : relation.where(model: @model)
+  end
+
+  def embedding_requests_for(input_hashes)
+    relation = EmbeddingRequest.where(input_hash: input_hashes)
+    @model == ALL_MODELS ? relation : relation.where(model: @model)
+  end
+end
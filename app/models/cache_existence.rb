@@ -0,0 +1,29 @@
+# Reports which inputs are already cached, without returning vectors or calling upstream.
+class CacheExistence
+  include ActiveModel::Model
+
+  attr_accessor :model, :dimensions, :inputs, :api_key, :tenant
+
+  validates :model, presence: true, inclusion: { in: EmbeddingForm::MODEL_NAMES }
+  validates :api_key, presence: true, format: { with: EmbeddingForm::API_KEY_FORMAT }
+  validates :dimensions, numericality: { only_integer: true, greater_than: 1, less_than: 10_000 }, allow_nil: true
+
+  def initialize(attributes = {})
+    super
+    @targets = EmbeddingTarget.build_targets!(inputs)
+  end
+
+  # one boolean per input, in input order
+  def results
+    cached_sha1sums = ApplicationRecord.reading_from_replica do
+      VectorCache.current_key_version.unexpired.where(input_hash: @targets.map(&:sha1sum), model: model, dimensions: dimensions || default_dimensions, tenant: tenant.to_s).pluck(:input_hash)
+    end.to_set
+    @targets.map { |target| cached_sha1sums.include?(target.sha1sum) }
+  end
+
+  private
+
+  def default_dimensions
+    ApplicationRecord.reading_from_replica { EmbeddingModel.find_by(name: model)&.default_dimensions }
+  end
+end
@@ -0,0 +1,55 @@
+# Embeds a sample of cached entries with stored input text again and compares
+# the fresh vectors with the cached ones, to detect drift or corruption.
+class CacheVerifier
+  Result = Struct.new(:input_hash, :dimensions, :similarity, keyword_init: true)
+
+  def initialize(model:, api_key:, sample_size: 100, threshold: 0.99, batch_size: 100)
+    @model = model
+    @api_key = api_key
+    @sample_size = sample_size
+    @threshold = threshold
+    @batch_size = batch_size
+  end
+
+  # returns one Result per sampled entry
+  def run
+    sampled_ids = VectorCache.current_key_version.where(model: @model).where.not(input_text: nil).order(random_order).limit(@sample_size).pluck(:id)
+    VectorCache.where(id: sampled_ids).group_by(&:dimensions).flat_map do |dimensions, vectors|
+      vectors.each_slice(@batch_size).flat_map { |batch| verify(batch, dimensions) }
+    end
+  end
+
+  def mismatch?(result)
+    result.similarity < @threshold
+  end
+
+  private
+
+  # sampled by the database, so only sample_size ids are loaded
+  def random_order
+    Arel.sql(VectorCache.connection.adapter_name.match?(/mysql|trilogy/i) ? "RAND()" : "RANDOM()")
+  end
+
+  def verify(vectors, dimensions)
+    targets = vectors.map { |vector| EmbeddingTarget.new(vector.input_text) }
+    response = UpstreamClient.new(api_key: @api_key, model: @model, dimensions: requested_dimensions(dimensions), targets: targets).post
+    vectors.zip(response.vector_cache_hashes).map do |vector, hash|
+      fresh = VectorQuantizer.dequantize(hash[:content], hash[:quantization])
+      Result.new(input_hash: vector.input_hash, dimensions: dimensions, similarity: cosine_similarity(vector.float_array_content, fresh))
+    end
+  end
+
+  # entries stored under the model's default dimensions were requested without dimensions
+  def requested_dimensions(dimensions)
+    dimensions unless EmbeddingModel.find_by(name: @model)&.default_dimensions == dimensions
+  end
+
+  def cosine_similarity(a, b)
+    return 0.0 unless a.size == b.size
+
+    denominator = Math.sqrt(a.sum { |v| v * v }) * Math.sqrt(b.sum { |v| v * v })
+    return 0.0 if denominator.zero?
+
+    a.zip(b).sum { |x, y| x * y } / denominator
+  end
+end
@@ -0,0 +1,120 @@
+class ConfigurationDoctor
+  Check = Struct.new(:name, :passed, :detail, keyword_init: true)
+
+  def initialize(probe_upstream: false)
+    @probe_upstream = probe_upstream
+  end
+
+  def checks
+    @checks ||= [
+      check_database,
+      check_migrations,
+      check_tables,
+      check_cache_index,
+      check_cache_entries,
+      *check_api_key_pattern,
+      check_allowed_models,
+      (check_upstream if @probe_upstream)
+    ].compact
+  end
+
+  def passed?
+    checks.all?(&:passed)
+  end
+
+  # prints the checklist and returns whether every check passed
+  def run(io = $stdout)
+    checks.each do |check|
+      line = "[#{check.passed ? "PASS" : "FAIL"}] #{check.name}"
+      line += ": #{check.detail}" if check.detail.present?
+      io.puts line
+    end
+    passed?
+  end
+
+  private
+
+  def check_database
+    ActiveRecord::Base.connection.select_value("SELECT 1")
+    Check.new(name: "database is reachable", passed: true, detail: ActiveRecord::Base.connection.adapter_name)
+  rescue StandardError => e
+    Check.new(name: "database is reachable", passed: false, detail: "#{e.message} (check DATABASE_URL)")
+  end
+
+  def check_migrations
+    pending = ActiveRecord::Base.connection_pool.migration_context.needs_migration?
+    Check.new(name: "migrations are current", passed: !pending, detail: pending ? "run bin/rails db:migrate" : nil)
+  rescue StandardError => e
+    Check.new(name: "migrations are current", passed: false, detail: e.message)
+  end
+
+  def check_tables
+    missing = [ VectorCache, EmbeddingModel, EmbeddingRequest, IdempotentResponse ].map(&:table_name).reject { |table| ActiveRecord::Base.connection.table_exists?(table) }
+    Check.new(
+      name: "tables exist",
+      passed: missing.empty?,
+      detail: missing.any? ? "missing #{missing.join(", ")} (run bin/rails db:prepare, and check CACHEMBED_TABLE_NAME_PREFIX)" : nil
+    )
+  rescue StandardError => e
+    Check.new(name: "tables exist", passed: false, detail: e.message)
+  end
+
+  def check_cache_index
+    present = ActiveRecord::Base.connection.index_exists?(VectorCache.table_name, [ :input_hash, :model, :dimensions, :key_version, :tenant ], unique: true)
+    Check.new(name: "cache lookup index exists", passed: present, detail: present ? nil : "run bin/rails db:migrate")
+  rescue StandardError => e
+    Check.new(name: "cache lookup index exists", passed: false, detail: e.message)
+  end
+
+  # informational: an empty cache is valid, but explains a 0% hit rate
+  def check_cache_entries
+    count = VectorCache.count
+    Check.new(
+      name: "cache entries",
+      passed: true,
+      detail: count.zero? ? "none yet; entries are stored on the first POST /v1/embeddings for each input" : count.to_s
+    )
+  rescue StandardError => e
+    Check.new(name: "cache entries", passed: false, detail: e.message)
+  end
+
+  def check_api_key_pattern
+    pattern = api_key_format
+    [
+      Check.new(name: "API key pattern compiles", passed: true),
+      Check.new(
+        name: "API key pattern rejects an empty key",
+        passed: !pattern.match?(""),
+        detail: pattern.match?("") ? "CACHEMBED_API_KEY_PATTERN matches everything" : nil
+      )
+    ]
+  rescue RegexpError => e
+    [ Check.new(name: "API key pattern compiles", passed: false, detail: "#{e.message} (check CACHEMBED_API_KEY_PATTERN)") ]
+  end
+
+  # compiled here because EmbeddingForm refuses to load with an invalid CACHEMBED_API_KEY_PATTERN
+  def api_key_format
+    pattern = ENV["CACHEMBED_API_KEY_PATTERN"]
+    pattern.nil? ? EmbeddingForm::API_KEY_FORMAT : /\A#{pattern}\z/
+  end
+
+  def check_allowed_models
+    models = EmbeddingForm::MODEL_NAMES
+    Check.new(
+      name: "allowed models is not empty",
+      passed: models.any?,
+      detail: models.any? ? models.join(",") : "set CACHEMBED_ALLOWED_MODELS"
+    )
+  rescue ArgumentError => e
+    Check.new(name: "allowed models is not empty", passed: false, detail: e.message)
+  end
+
+  def check_upstream
+    return Check.new(name: "upstream is reachable", passed: true, detail: "#{UpstreamClient::URL} is a mock upstream") if MockUpstream.url?(UpstreamClient::URL)
+
+    response = Faraday.head(UpstreamClient::URL)
+    Check.new(name: "upstream is reachable", passed: true, detail: "#{UpstreamClient::URL} answered #{response.status}")
+  rescue Faraday::Error => e
+    Check.new(name: "upstream is reachable", passed: false, detail: "#{UpstreamClient::URL}: #{e.message} (check CACHEMBED_UPSTREAM_URL)")
+  end
+end
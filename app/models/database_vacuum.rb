@@ -0,0 +1,90 @@
+# Returns the space freed by deleted cache entries to the operating system.
+#
+# - SQLite:     checkpoints and truncates the WAL, then VACUUM (or incremental_vacuum)
+# - PostgreSQL: VACUUM (ANALYZE) of the cache table
+# - MySQL:      OPTIMIZE TABLE of the cache table
+class DatabaseVacuum
+  class InsufficientSpaceError < StandardError; end
+
+  def initialize(incremental: false, io: $stdout)
+    @incremental = incremental
+    @io = io
+  end
+
+  def run
+    case connection.adapter_name
+    when /sqlite/i
+      vacuum_sqlite
+    when /postg/i
+      report_size("#{table} table") { connection.execute("VACUUM (ANALYZE) #{table}") }
+    when /mysql|trilogy/i
+      report_size("#{table} table") { connection.execute("OPTIMIZE TABLE #{table}") }
+    else
+      raise ArgumentError, "Vacuum is not supported on #{connection.adapter_name}"
+    end
+  end
+
+  private
+
+  def vacuum_sqlite
+    report_size("database and WAL files") do
+      connection.execute("PRAGMA wal_checkpoint(TRUNCATE)")
+      if @incremental
+        @io.puts "auto_vacuum is not incremental; incremental_vacuum frees nothing until a full VACUUM enables it" unless connection.select_value("PRAGMA auto_vacuum").to_i == 2
+        connection.execute("PRAGMA incremental_vacuum")
+      else
+        ensure_space_for_sqlite_vacuum!
+        connection.execute("VACUUM")
+      end
+    end
+  end
+
+  # VACUUM writes a full copy of the database before replacing it
+  def ensure_space_for_sqlite_vacuum!
+    free = free_bytes
+    return if free.nil? || free >= database_bytes
+
+    raise InsufficientSpaceError, "VACUUM needs about #{database_bytes} bytes free next to the database, only #{free} available"
+  end
+
+  def report_size(subject)
+    before = database_bytes
+    yield
+    @io.puts "#{subject}: #{before} bytes before, #{database_bytes} bytes after"
+  end
+
+  def database_bytes
+    case connection.adapter_name
+    when /sqlite/i
+      [ database_path, "#{database_path}-wal" ].sum { |path| File.exist?(path) ? File.size(path) : 0 }
+    when /postg/i
+      connection.select_value("SELECT pg_total_relation_size(#{connection.quote(VectorCache.table_name)})").to_i
+    else
+      connection.select_value(<<~SQL).to_i
+        SELECT data_length + index_length FROM information_schema.tables
+        WHERE table_schema = DATABASE() AND table_name = #{connection.quote(VectorCache.table_name)}
+      SQL
+    end
+  end
+
+  # nil when df is unavailable; the check is then skipped
+  def free_bytes
+    output = IO.popen([ "df", "-Pk", File.dirname(File.expand_path(database_path)) ], err: File::NULL, &:read)
+    available_kb = output.lines.last&.split&.at(3)
+    available_kb&.match?(/\A\d+\z/) ? available_kb.to_i * 1024 : nil
+  rescue SystemCallError
+    nil
+  end
+
+  def database_path
+    ActiveRecord::Base.connection_db_config.database
+  end
+
+  def table
+    connection.quote_table_name(VectorCache.table_name)
+  end
+
+  def connection
+    ActiveRecord::Base.connection
+  end
+end
@@ -0,0 +1,137 @@
+# Sends POST /v1/embeddings requests to the application in-process, through the
+# full middleware stack, and reports latency percentiles, throughput and the SQL
+# statements they ran. Each request embeds one input; a hit_ratio share of them
+# reuse inputs cached during warm-up. Entries and request logs created by the run
+# are deleted afterwards.
+class EmbeddingBenchmark
+  HOT_INPUTS = 100
+  DELETE_BATCH_SIZE = 1000
+
+  Result = Struct.new(:requests, :concurrency, :hit_ratio, :errors, :seconds, :latencies, :reads, :writes, keyword_init: true) do
+    # milliseconds
+    def percentile(percent)
+      sorted = latencies.sort
+      (sorted[((sorted.size - 1) * percent / 100.0).round] * 1000).round(2)
+    end
+
+    def throughput
+      (requests / seconds).round(1)
+    end
+
+    def to_h
+      {
+        requests: requests,
+        concurrency: concurrency,
+        hit_ratio: hit_ratio,
+        errors: errors,
+        seconds: seconds.round(3),
+        requests_per_second: throughput,
+        latency_ms: { p50: percentile(50), p95: percentile(95), p99: percentile(99) },
+        sql_statements: { reads: reads, writes: writes }
+      }
+    end
+  end
+
+  def initialize(requests:, concurrency:, input_size:, hit_ratio:, model:, api_key:, app: Rails.application, seed: Random.new_seed)
+    raise ArgumentError, "HIT_RATIO must be between 0 and 1, got #{hit_ratio}" unless (0.0..1.0).cover?(hit_ratio)
+    raise ArgumentError, "REQUESTS and CONCURRENCY must be positive" unless requests.positive? && concurrency.positive?
+
+    @requests = requests
+    @concurrency = concurrency
+    @input_size = input_size
+    @hit_ratio = hit_ratio
+    @model = model
+    @api_key = api_key
+    @app = app
+    @random = Random.new(seed)
+    @run_id = SecureRandom.hex(4)
+    @inputs = []
+  end
+
+  def run
+    hot_inputs = Array.new(HOT_INPUTS) { |index| input_text("hot-#{index}") }
+    hot_inputs.each { |input| post(input) }
+    plan = Array.new(@requests) { |index| @random.rand < @hit_ratio ? hot_inputs.sample(random: @random) : input_text("miss-#{index}") }
+
+    reads = Concurrent::AtomicFixnum.new
+    writes = Concurrent::AtomicFixnum.new
+    counter = lambda do |_name, _start, _finish, _id, payload|
+      next if payload[:name] == "SCHEMA" || payload[:cached]
+
+      (payload[:sql].match?(/\A\s*(SELECT|PRAGMA)/i) ? reads : writes).increment
+    end
+
+    results = nil
+    started_at = monotonic_now
+    ActiveSupport::Notifications.subscribed(counter, "sql.active_record") { results = drive(plan) }
+    seconds = monotonic_now - started_at
+
+    Result.new(
+      requests: @requests,
+      concurrency: @concurrency,
+      hit_ratio: @hit_ratio,
+      errors: results.count { |_, status| status != 200 },
+      seconds: seconds,
+      latencies: results.map(&:first),
+      reads: reads.value,
+      writes: writes.value
+    )
+  ensure
+    cleanup
+  end
+
+  private
+
+  # returns [seconds, status] per request
+  def drive(plan)
+    queue = Queue.new
+    plan.each { |input| queue << input }
+    queue.close
+    Array.new(@concurrency) do
+      Thread.new do
+        results = []
+        while (input = queue.pop)
+          started_at = monotonic_now
+          status = post(input)
+          results << [ monotonic_now - started_at, status ]
+        end
+        results
+      end
+    end.flat_map(&:value)
+  end
+
+  def post(input)
+    env = Rack::MockRequest.env_for(
+      "/v1/embeddings",
+      method: "POST",
+      input: { model: @model, input: input }.to_json,
+      "CONTENT_TYPE" => "application/json",
+      "HTTP_ACCEPT" => "application/json",
+      "HTTP_HOST" => "localhost",
+      "HTTP_AUTHORIZATION" => "Bearer #{@api_key}"
+    )
+    env["HTTP_#{TenantPartitioning::TENANT_HEADER.upcase.tr("-", "_")}"] = "cachembed-bench" if TenantPartitioning::TENANT_HEADER
+    status, _headers, body = @app.call(env)
+    body.close if body.respond_to?(:close)
+    status
+  rescue StandardError => e
+    Rails.logger.error("Benchmark request failed: #{e.class}: #{e.message}")
+    nil
+  end
+
+  def input_text(name)
+    text = "cachembed-bench #{@run_id} #{name} "
+    (text * (@input_size / text.size + 1))[0, [ @input_size, text.size ].max].tap { |input| @inputs << input }
+  end
+
+  def cleanup
+    @inputs.uniq.map { |input| EmbeddingTarget.new(input).sha1sum }.each_slice(DELETE_BATCH_SIZE) do |input_hashes|
+      VectorCache.where(input_hash: input_hashes).delete_all
+      EmbeddingRequest.where(input_hash: input_hashes).delete_all
+    end
+  end
+
+  def monotonic_now
+    Process.clock_gettime(Process::CLOCK_MONOTONIC)
+  end
+end
@@ -2,7 +2,7 @@ class EmbeddingForm
   include ActiveModel::Model
   include ActiveModel::Attributes
 
-  attr_accessor :model, :dimensions, :encoding_format, :api_key, :targets, :input
+  attr_accessor :model, :dimensions, :encoding_format, :api_key, :targets, :input, :expires_in, :tenant
   attr_reader :prompt_tokens, :total_tokens
 
   MODEL_NAMES = ENV.fetch("CACHEMBED_ALLOWED_MODELS", "text-embedding-ada-002,text-embedding-3-small,text-embedding-3-large").split(",")
@@ -12,16 +12,84 @@ class EmbeddingForm
 
   validates :model, presence: true, inclusion: { in: MODEL_NAMES }
   validates :dimensions, numericality: { only_integer: true, greater_than: 1, less_than: 10_000 }, allow_nil: true
+  # the smallest dimensions the validation above accepts
+  MIN_DIMENSIONS = 2
+  validate :dimensions_must_be_integer
+
+  # keeps the cache keyspace free of entries stored under the model's default dimensions
+  REQUIRE_DIMENSIONS = ENV.fetch("CACHEMBED_REQUIRE_DIMENSIONS", "false") == "true"
+
+  validates :dimensions, presence: true, if: -> { REQUIRE_DIMENSIONS }
   validates :encoding_format, inclusion: { in: ENCODING_FORMATS }, allow_nil: true
 
+  STRICT_MODEL_DIMENSION = ENV.fetch("CACHEMBED_STRICT_MODEL_DIMENSION", "false") == "true"
+  # e.g. "text-embedding-3-small:2..1536,text-embedding-3-large:2..3072"
+  MODEL_DIMENSIONS = ENV.fetch("CACHEMBED_MODEL_DIMENSIONS", "text-embedding-3-small:2..1536,text-embedding-3-large:2..3072").split(",").to_h do |entry|
+    name, range = entry.split(":", 2)
+    min, max = range.split("..").map(&:to_i)
+    # a lower minimum could never pass the dimensions validation above
+    [ name, [ min, MIN_DIMENSIONS ].max..max ]
+  end
+
+  validate :dimensions_supported_by_model, if: -> { STRICT_MODEL_DIMENSION }
+
+  # e.g. "text-embedding-ada-002:8191"; models without an entry are not checked
+  MODEL_MAX_TOKENS = ENV.fetch("CACHEMBED_MODEL_MAX_TOKENS", "").split(",").to_h do |entry|
+    name, max_tokens = entry.split(":", 2)
+    [ name, max_tokens.to_i ]
+  end
+
+  validate :token_inputs_within_model_limit
+
+  # X-Cachembed-Expires-In: seconds, or a number followed by s, m, h or d
+  EXPIRES_IN_FORMAT = /\A(\d+)([smhd])?\z/
+  EXPIRES_IN_UNITS = { "s" => 1.second, "m" => 1.minute, "h" => 1.hour, "d" => 1.day }.freeze
+
+  validate :expires_in_must_be_duration
+
+  # models not listed here are proxied without touching the cache; empty means all models are cached
+  CACHE_ONLY_MODELS = ENV.fetch("CACHEMBED_CACHE_ONLY_MODELS", "").split(",")
+
+  # upstream: report what upstream charged, zero-on-hit: report 0 when any input was cached,
+  # stored: add the tokens recorded for cached inputs to what upstream charged
+  USAGE_MODES = %w[upstream zero-on-hit stored].freeze
+  USAGE_MODE = ENV.fetch("CACHEMBED_USAGE_MODE", "upstream")
+  raise ArgumentError, "CACHEMBED_USAGE_MODE must be one of #{USAGE_MODES.join(", ")}, got #{USAGE_MODE}" unless USAGE_MODES.include?(USAGE_MODE)
+
+  # serve cache hits without writing request logs or access counts, e.g. on a read replica
+  NO_TOUCH = ENV.fetch("CACHEMBED_NO_TOUCH", "false") == "true"
+
+  # longer inputs (bytes for strings, tokens for token arrays) are proxied but not stored
+  MAX_CACHED_INPUT_LENGTH = ENV["CACHEMBED_MAX_CACHED_INPUT_LENGTH"]&.to_i
+
+  # respond before upstream vectors are stored; see VectorCacheWriter
+  CACHE_WRITE_ASYNC = ENV.fetch("CACHEMBED_CACHE_WRITE_ASYNC", "false") == "true"
+
+  # fail-open: serve from upstream without the cache when the database errors,
+  # fail-closed: let the error through so the request is answered with 503
+  DB_FAILURE_MODES = %w[fail-open fail-closed].freeze
+  DB_FAILURE_MODE = ENV.fetch("CACHEMBED_DB_FAILURE_MODE", "fail-open")
+  raise ArgumentError, "CACHEMBED_DB_FAILURE_MODE must be one of #{DB_FAILURE_MODES.join(", ")}, got #{DB_FAILURE_MODE}" unless DB_FAILURE_MODES.include?(DB_FAILURE_MODE)
+
+  WARN_ON_LARGE_VECTORS = ENV.fetch("CACHEMBED_WARN_ON_LARGE_VECTORS", "false") == "true"
+
   API_KEY_PATTERN = ENV.fetch("CACHEMBED_API_KEY_PATTERN", "^sk-[a-zA-Z0-9_-]+$")
 
-  validates :api_key, presence: true, format: { with: /\A#{API_KEY_PATTERN}\z/ }
+  def self.api_key_format(pattern)
+    /\A#{pattern}\z/
+  rescue RegexpError => e
+    raise ArgumentError, "CACHEMBED_API_KEY_PATTERN must be a valid regular expression, got #{pattern.inspect} (#{e.message})"
+  end
+
+  API_KEY_FORMAT = api_key_format(API_KEY_PATTERN)
+
+  validates :api_key, presence: true, format: { with: API_KEY_FORMAT }
   validates :targets, presence: true
 
   def initialize(attributes = {})
     super
     self.encoding_format ||= DEFAULT_ENCODING_FORMAT
+    self.tenant ||= ""
     self.targets = EmbeddingTarget.build_targets!(attributes[:input])
     @prompt_tokens = 0
     @total_tokens = 0
@@ -30,23 +98,26 @@ class EmbeddingForm
   def save!
     raise ActiveRecord::RecordInvalid.new(self) unless valid?
 
-    save_embedding_requests!
+    # with NO_TOUCH only misses are logged, so requests answered from the cache write nothing
+    logged_targets = NO_TOUCH ? upstream_targets : targets
+    with_database_fallback(nil) { save_embedding_requests!(logged_targets) } if cacheable? && logged_targets.any?
 
     vector_by_sha1sum = cached_vectors.index_by(&:input_hash)
+    with_database_fallback(nil) { VectorCache.update_counters(cached_vectors.map(&:id), access_count: 1, touch: :last_accessed_at) } if cached_vectors.any? && !NO_TOUCH
 
     if upstream_targets.any?
       response = upstream_client.post
-      upstream_vectors = VectorCache.import_from_response!(response)
-      if dimensions.nil? && default_dimensions.nil?
-        save_default_dimensions!(upstream_vectors.first.dimensions)
-      end
+      upstream_vectors = cacheable? ? import_upstream_vectors!(response) : VectorCache.build_from_response(response)
       @prompt_tokens = response.prompt_tokens
+      @upstream_prompt_tokens = response.prompt_tokens
       @total_tokens = response.total_tokens
       upstream_vectors.each do |vector|
         vector_by_sha1sum[vector.input_hash] = vector
       end
     end
 
+    apply_usage_mode
+
     targets.map.with_index do |target, index|
       {
         object: "embedding",
@@ -56,10 +127,141 @@ class EmbeddingForm
     end
   end
 
+  def cacheable?
+    CACHE_ONLY_MODELS.empty? || CACHE_ONLY_MODELS.include?(model)
+  end
+
+  # what upstream would have charged without the cache, regardless of CACHEMBED_USAGE_MODE
+  def would_have_cost_tokens
+    @upstream_prompt_tokens.to_i + cached_prompt_tokens
+  end
+
+  def store_failed?
+    @store_failed == true
+  end
+
+  def cache_hits
+    targets.size - upstream_targets.size
+  end
+
+  def cache_misses
+    upstream_targets.size
+  end
+
   private
 
+  def apply_usage_mode
+    return if cached_vectors.empty?
+
+    case USAGE_MODE
+    when "zero-on-hit"
+      @prompt_tokens = 0
+      @total_tokens = 0
+    when "stored"
+      @prompt_tokens += cached_prompt_tokens
+      @total_tokens += cached_prompt_tokens
+    end
+  end
+
+  # tokens recorded for the cached inputs; entries stored before prompt_tokens was recorded count as 0
+  def cached_prompt_tokens
+    tokens_by_sha1sum = cached_vectors.to_h { |vector| [ vector.input_hash, vector.prompt_tokens.to_i ] }
+    targets.sum { |target| tokens_by_sha1sum.fetch(target.sha1sum, 0) }
+  end
+
+  def import_upstream_vectors!(response)
+    oversized_sha1sums = oversized_targets.map(&:sha1sum)
+    oversized_hashes, vector_cache_hashes = response.vector_cache_hashes.partition { |hash| oversized_sha1sums.include?(hash[:input_hash]) }
+    Rails.logger.debug("Not caching #{oversized_hashes.size} inputs longer than #{MAX_CACHED_INPUT_LENGTH}") if oversized_hashes.any?
+    vector_cache_hashes = vector_cache_hashes.map { |hash| hash.merge(tenant: tenant) }
+    vector_cache_hashes = vector_cache_hashes.map { |hash| hash.merge(expires_at: expires_at) } if expires_in.present?
+
+    upstream_vectors = if CACHE_WRITE_ASYNC
+      VectorCacheWriter.enqueue(vector_cache_hashes) if vector_cache_hashes.any?
+      vector_cache_hashes.map { |hash| VectorCache.new(hash) }
+    else
+      store_vector_cache_hashes(vector_cache_hashes)
+    end
+    upstream_vectors += oversized_hashes.map { |hash| VectorCache.new(hash) }
+    warn_on_large_vectors(upstream_vectors) if WARN_ON_LARGE_VECTORS
+    if dimensions.nil? && default_dimensions.nil?
+      with_database_fallback(nil) { save_default_dimensions!(upstream_vectors.first.dimensions) }
+    end
+    upstream_vectors
+  end
+
+  # with CACHEMBED_DB_FAILURE_MODE=fail-open a failed store still returns the vectors, unsaved
+  def store_vector_cache_hashes(vector_cache_hashes)
+    stored_vectors = with_database_fallback(nil) { VectorCache.import_hashes!(vector_cache_hashes) }
+    return stored_vectors unless stored_vectors.nil?
+
+    @store_failed = true
+    vector_cache_hashes.map { |hash| VectorCache.new(hash) }
+  end
+
+  def dimensions_must_be_integer
+    return if dimensions.nil? || dimensions.is_a?(Integer)
+
+    errors.add(:dimensions, "must be an integer, not #{dimensions.class.name.downcase}")
+  end
+
+  def dimensions_supported_by_model
+    return if dimensions.nil?
+
+    range = MODEL_DIMENSIONS[model]
+    if range.nil?
+      errors.add(:dimensions, "is not supported by #{model}")
+    elsif !range.cover?(dimensions.to_i)
+      errors.add(:dimensions, "must be between #{range.min} and #{range.max} for #{model}")
+    end
+  end
+
+  # diagnostic only: flags vectors larger than the requested (or default) dimensions imply
+  def warn_on_large_vectors(vectors)
+    expected_dimensions = dimensions || default_dimensions
+    return if expected_dimensions.nil?
+
+    expected_bytesize = expected_dimensions.to_i * VectorCache::BYTES_PER_DIMENSION
+    vectors.each do |vector|
+      next if vector.content.bytesize <= expected_bytesize
+
+      Rails.logger.warn("Large vector for #{vector.input_hash}: #{vector.content.bytesize} bytes, expected at most #{expected_bytesize} bytes for #{model} with #{expected_dimensions} dimensions")
+    end
+  end
+
+  def expires_in_must_be_duration
+    return if expires_in.blank?
+    return if EXPIRES_IN_FORMAT.match?(expires_in.to_s) && expires_in.to_s.to_i.positive?
+
+    errors.add(:expires_in, "must be a positive duration such as 3600, 90m, 12h or 30d")
+  end
+
+  def expires_at
+    @expires_at ||= begin
+      amount, unit = EXPIRES_IN_FORMAT.match(expires_in.to_s).captures
+      amount.to_i * EXPIRES_IN_UNITS.fetch(unit || "s")
+    end.from_now
+  end
+
+  def token_inputs_within_model_limit
+    max_tokens = MODEL_MAX_TOKENS[model]
+    return if max_tokens.nil? || targets.nil?
+
+    targets.each_with_index do |target, index|
+      next unless target.is_token? && target.input_length > max_tokens
+
+      errors.add(:input, "at index #{index} has #{target.input_length} tokens, exceeding the maximum of #{max_tokens} for #{model}")
+    end
+  end
+
   def cached_vectors
-    @cached_vectors ||= VectorCache.where(input_hash: targets.map(&:sha1sum), model: model, dimensions: dimensions || default_dimensions).all
+    return [] unless cacheable?
+
+    @cached_vectors ||= with_database_fallback([]) do
+      ApplicationRecord.reading_from_replica do
+        VectorCache.current_key_version.unexpired.where(input_hash: targets.map(&:sha1sum), model: model, dimensions: dimensions || default_dimensions, tenant: tenant).to_a
+      end
+    end
   end
 
   def upstream_targets
@@ -67,6 +269,12 @@ class EmbeddingForm
     targets.reject { |target| cached_sha1sums.include?(target.sha1sum) }
   end
 
+  def oversized_targets
+    return [] if MAX_CACHED_INPUT_LENGTH.nil?
+
+    upstream_targets.select { |target| target.input_length > MAX_CACHED_INPUT_LENGTH }
+  end
+
   def upstream_client
     UpstreamClient.new(
       api_key: api_key,
@@ -77,14 +285,25 @@ class EmbeddingForm
   end
 
   def default_dimensions
-    @default_dimensions ||= EmbeddingModel.find_by(name: model)&.default_dimensions
+    @default_dimensions ||= with_database_fallback(nil) do
+      ApplicationRecord.reading_from_replica { EmbeddingModel.find_by(name: model)&.default_dimensions }
+    end
+  end
+
+  def with_database_fallback(fallback)
+    yield
+  rescue ActiveRecord::ConnectionNotEstablished, ActiveRecord::StatementInvalid => e
+    raise unless DB_FAILURE_MODE == "fail-open"
+
+    Rails.logger.warn("Bypassing the cache after a database error: #{e.message}")
+    fallback
   end
 
   def save_default_dimensions!(d)
-    EmbeddingModel.create!(name: model, default_dimensions: d)
+    EmbeddingModel.find_or_create_by!(name: model) { |embedding_model| embedding_model.default_dimensions = d }
   end
 
-  def save_embedding_requests!
+  def save_embedding_requests!(targets)
     EmbeddingRequest.insert_all!(
       targets.map do |target|
         {
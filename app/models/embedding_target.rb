@@ -1,6 +1,9 @@
 class EmbeddingTarget
   class InvalidInputError < StandardError; end
 
+  # how much of an invalid input is quoted back in the error message
+  MAX_ECHOED_INPUT_LENGTH = 100
+
   def initialize(value)
     @value = value
   end
@@ -10,19 +13,34 @@ class EmbeddingTarget
   end
 
   def self.build_targets!(input)
-    if input.is_a?(String)
+    if input.nil?
+      raise InvalidInputError, "input is required"
+    elsif input.is_a?(Array) && input.empty?
+      raise InvalidInputError, "input must not be empty"
+    elsif input == ""
+      raise InvalidInputError, "input must not be an empty string"
+    elsif input.is_a?(String)
       [ new(input) ]
     elsif input.is_a?(Array) && input.all? { |v| v.is_a?(Integer) }
       [ new(input) ]
     elsif input.is_a?(Array) && input.all? { |v| v.is_a?(String) }
+      reject_empty_elements!(input)
       input.map { |str| new(str) }
     elsif input.is_a?(Array) && input.all? { |v| v.is_a?(Array) && v.all? { |j| j.is_a?(Integer) } }
+      reject_empty_elements!(input)
       input.map { |tokens| new(tokens) }
     else
-      raise "Invalid input format: #{input}, allowed formats: String, Array of Integers, Array of Strings, Array of Arrays of Integers"
+      raise InvalidInputError, "Invalid input format: #{input.to_s.truncate(MAX_ECHOED_INPUT_LENGTH)}, allowed formats: String, Array of Integers, Array of Strings, Array of Arrays of Integers"
     end
   end
 
+  # upstream rejects them too, but without saying which element was empty
+  def self.reject_empty_elements!(input)
+    index = input.index(&:empty?)
+    raise InvalidInputError, "input at index #{index} must not be empty" unless index.nil?
+  end
+  private_class_method :reject_empty_elements!
+
   def sha1sum
     @sha1sum ||= Digest::SHA1.hexdigest(sha1sum_source)
   end
@@ -35,6 +53,15 @@ class EmbeddingTarget
     end
   end
 
+  # token inputs are tokenizer-specific, so only string inputs have a reusable text
+  def input_text
+    @value if is_string?
+  end
+
+  def is_token?
+    !is_string?
+  end
+
   private
 
   def sha1sum_source
@@ -48,8 +75,4 @@ class EmbeddingTarget
   def is_string?
     @value.is_a?(String)
   end
-
-  def is_token?
-    !is_string?
-  end
 end
@@ -0,0 +1,42 @@
+# A response stored for an Idempotency-Key, replayed to retries within CACHEMBED_IDEMPOTENCY_KEY_TTL.
+class IdempotentResponse < ApplicationRecord
+  # only these response headers are replayed
+  HEADER_PREFIX = "x-cachembed-"
+  # the body column's limit; larger responses are served but not stored for replay
+  MAX_BODY_BYTES = 16.megabytes
+
+  serialize :headers, coder: JSON
+
+  validates :key_digest, presence: true
+  validates :request_digest, presence: true
+  validates :status, presence: true
+  validate :body_fits_column
+
+  scope :expired, -> { where(expires_at: ..Time.current) }
+  scope :unexpired, -> { where(expires_at: Time.current..) }
+
+  def self.digest(*parts)
+    Digest::SHA256.hexdigest(parts.join("\0"))
+  end
+
+  # the first response for a key wins; an expired one is replaced
+  def self.store!(key_digest:, request_digest:, status:, body:, headers:, expires_at:)
+    expired.where(key_digest: key_digest).delete_all
+    create!(
+      key_digest: key_digest,
+      request_digest: request_digest,
+      status: status,
+      body: body,
+      headers: headers.to_h.select { |name, _| name.downcase.start_with?(HEADER_PREFIX) },
+      expires_at: expires_at
+    )
+  rescue ActiveRecord::RecordNotUnique
+    nil
+  end
+
+  private
+
+  def body_fits_column
+    errors.add(:body, "is #{body.bytesize} bytes, larger than #{MAX_BODY_BYTES}") if body.to_s.bytesize > MAX_BODY_BYTES
+  end
+end
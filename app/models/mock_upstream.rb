@@ -0,0 +1,59 @@
+require "digest"
+
+# Stands in for upstream when CACHEMBED_UPSTREAM_URL is mock://deterministic?dim=1536&seed=ci,
+# so integration tests can run without credentials. The embeddings are fake: each one
+# is derived from a SHA-256 of the seed, model and input, so it is the same in every process.
+class MockUpstream
+  SCHEME = "mock"
+  DEFAULT_DIMENSIONS = 1536
+
+  def self.url?(url)
+    URI.parse(url).scheme == SCHEME
+  rescue URI::InvalidURIError
+    false
+  end
+
+  def initialize(url)
+    uri = URI.parse(url)
+    raise ArgumentError, "Unsupported mock upstream #{url}, expected mock://deterministic" unless uri.host == "deterministic"
+
+    params = URI.decode_www_form(uri.query.to_s).to_h
+    @dimensions = params.fetch("dim", DEFAULT_DIMENSIONS).to_i
+    @seed = params["seed"]
+  end
+
+  # answers an UpstreamClient#request_body the way the embeddings API would
+  def embed(request_body)
+    dimensions = request_body[:dimensions] || @dimensions
+    inputs = request_body[:input]
+    tokens = inputs.sum { |input| input.is_a?(Array) ? input.size : (input.bytesize / 4.0).ceil }
+    {
+      object: "list",
+      data: inputs.map.with_index do |input, index|
+        {
+          object: "embedding",
+          index: index,
+          embedding: format_embedding(vector(request_body[:model], input, dimensions), request_body[:encoding_format])
+        }
+      end,
+      model: request_body[:model],
+      usage: { prompt_tokens: tokens, total_tokens: tokens }
+    }
+  end
+
+  private
+
+  def format_embedding(values, encoding_format)
+    encoding_format == "float" ? VectorEncoding.unpack(VectorEncoding.pack(values)) : VectorEncoding.encode_base64(VectorEncoding.pack(values))
+  end
+
+  # SHA-256 in counter mode, scaled to [-1, 1) and normalized to unit length like real embeddings
+  def vector(model, input, dimensions)
+    seed = [ @seed, model, input.is_a?(Array) ? input.join(",") : input ].compact.join("\0")
+    blocks = (dimensions * 4.0 / 32).ceil
+    bytes = Array.new(blocks) { |counter| Digest::SHA256.digest("#{seed}\0#{counter}") }.join
+    values = bytes.unpack("L<*").first(dimensions).map { |value| value / 2_147_483_648.0 - 1.0 }
+    norm = Math.sqrt(values.sum { |value| value * value })
+    values.map { |value| value / norm }
+  end
+end
@@ -0,0 +1,34 @@
+# Copies cache entries with stored input text from one model to another by
+# embedding the text again upstream.
+class Reembedder
+  def initialize(from_model:, to_model:, api_key:, dimensions: nil, batch_size: 100, tenant: "")
+    @from_model = from_model
+    @to_model = to_model
+    @api_key = api_key
+    @dimensions = dimensions
+    @batch_size = batch_size
+    @tenant = tenant
+  end
+
+  # returns the number of entries created for to_model
+  def run
+    created = 0
+    VectorCache.where(model: @from_model, tenant: @tenant).where.not(input_text: nil).in_batches(of: @batch_size) do |relation|
+      targets = missing_targets(relation.pluck(:input_text).uniq.map { |text| EmbeddingTarget.new(text) })
+      next if targets.empty?
+
+      response = UpstreamClient.new(api_key: @api_key, model: @to_model, dimensions: @dimensions, targets: targets).post
+      created += VectorCache.import_hashes!(response.vector_cache_hashes.map { |hash| hash.merge(tenant: @tenant) }).size
+    end
+    created
+  end
+
+  private
+
+  def missing_targets(targets)
+    existing = VectorCache.current_key_version.where(model: @to_model, tenant: @tenant, input_hash: targets.map(&:sha1sum))
+    existing = existing.where(dimensions: @dimensions) if @dimensions.present?
+    existing_hashes = existing.pluck(:input_hash)
+    targets.reject { |target| existing_hashes.include?(target.sha1sum) }
+  end
+end
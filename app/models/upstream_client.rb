@@ -4,6 +4,46 @@ require "uri"
 class UpstreamClient
   URL = ENV.fetch("CACHEMBED_UPSTREAM_URL", "https://api.openai.com/v1/embeddings")
 
+  # what is asked of upstream, independent of the client's encoding_format; both decode to the same stored float32
+  ENCODING_FORMATS = %w[base64 float].freeze
+  ENCODING_FORMAT = ENV.fetch("CACHEMBED_UPSTREAM_ENCODING_FORMAT", "base64")
+  raise ArgumentError, "CACHEMBED_UPSTREAM_ENCODING_FORMAT must be one of #{ENCODING_FORMATS.join(", ")}, got #{ENCODING_FORMAT}" unless ENCODING_FORMATS.include?(ENCODING_FORMAT)
+
+  # kept-alive connections to upstream, shared by the threads of a process
+  POOL_SIZE = Integer(ENV.fetch("CACHEMBED_UPSTREAM_POOL_SIZE", ENV.fetch("RAILS_MAX_THREADS", "3")), exception: false)
+  raise ArgumentError, "CACHEMBED_UPSTREAM_POOL_SIZE must be a positive integer, got #{ENV["CACHEMBED_UPSTREAM_POOL_SIZE"]}" unless POOL_SIZE&.positive?
+  IDLE_TIMEOUT = Integer(ENV.fetch("CACHEMBED_UPSTREAM_IDLE_TIMEOUT", "5"), exception: false)
+  raise ArgumentError, "CACHEMBED_UPSTREAM_IDLE_TIMEOUT must be a positive number of seconds, got #{ENV["CACHEMBED_UPSTREAM_IDLE_TIMEOUT"]}" unless IDLE_TIMEOUT&.positive?
+
+  def self.connection
+    @connection ||= Faraday.new(url: URL) do |faraday|
+      faraday.request :json
+      faraday.response :json, parser_options: { symbolize_names: true }
+      faraday.adapter :net_http_persistent, pool_size: POOL_SIZE do |http|
+        http.idle_timeout = IDLE_TIMEOUT
+      end
+    end
+  end
+
+  class UpstreamError < StandardError
+    attr_reader :status, :type, :code, :param, :upstream_message
+
+    def initialize(status:, body:)
+      @status = status
+      error = body.is_a?(Hash) && body[:error].is_a?(Hash) ? body[:error] : {}
+      @type = error[:type]
+      @code = error[:code]
+      @param = error[:param]
+      @upstream_message = error[:message] || body.to_s
+      super("Failed to get embedding from upstream: #{status}: #{body}")
+    end
+
+    # OpenAI-compatible error body relayed to the client
+    def to_hash
+      { message: upstream_message, type: type, param: param, code: code }
+    end
+  end
+
   attr_accessor :api_key
 
   def initialize(api_key:, model:, dimensions:, targets:)
@@ -17,26 +57,22 @@ class UpstreamClient
     body = {
       model: @model,
       input: @targets.map(&:to_hash),
-      encoding_format: "base64"
+      encoding_format: ENCODING_FORMAT
     }
     body[:dimensions] = @dimensions if @dimensions.present?
     body
   end
 
   def post
-    conn = Faraday.new(url: URL) do |faraday|
-      faraday.request :json
-      faraday.response :json, parser_options: { symbolize_names: true }
-      faraday.adapter Faraday.default_adapter
-    end
+    return UpstreamResponse.new(body: MockUpstream.new(URL).embed(request_body), targets: @targets, model: @model) if MockUpstream.url?(URL)
 
-    response = conn.post do |req|
+    response = self.class.connection.post do |req|
       req.headers["Authorization"] = "Bearer #{@api_key}"
       req.headers["Content-Type"] = "application/json"
       req.body = request_body
     end
     json_response = response.body
-    raise "Failed to get embedding from upstream: #{response.status}: #{response.body}" unless response.success?
+    raise UpstreamError.new(status: response.status, body: json_response) unless response.success?
 
     UpstreamResponse.new(body: json_response, targets: @targets, model: @model)
   end
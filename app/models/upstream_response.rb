@@ -1,6 +1,6 @@
-require "base64"
-
 class UpstreamResponse
+  class InvalidResponseError < StandardError; end
+
   attr_reader :body, :targets, :model
 
   def initialize(body:, targets:, model:)
@@ -11,12 +11,18 @@ class UpstreamResponse
 
   # targets の順番に対応した sha1sum と embedding のペアを返す
   def vector_cache_hashes
-    @targets.zip(body[:data]).map do |target, item|
+    validate!
+
+    @targets.zip(ordered_data, element_prompt_tokens).map do |target, item, tokens|
       {
         input_hash: target.sha1sum,
-        content: base64_decode(item[:embedding]),
+        prompt_tokens: tokens,
+        content: VectorQuantizer.quantize(decode_embedding(item), VectorCache::QUANTIZATION),
+        quantization: VectorCache::QUANTIZATION,
         model: @model,
-        dimensions: dimensions
+        dimensions: dimensions,
+        key_version: VectorCache::KEY_VERSION,
+        input_text: VectorCache::STORE_INPUT_TEXT ? target.input_text : nil
       }
     end
   end
@@ -29,13 +35,65 @@ class UpstreamResponse
     @body[:usage][:total_tokens]
   end
 
+  # upstream reports usage per request, so it is split across the inputs by
+  # input_length (exact for token inputs, byte-proportional for strings)
+  def element_prompt_tokens
+    @element_prompt_tokens ||= begin
+      total = prompt_tokens.to_i
+      weights = @targets.map(&:input_length)
+      weight_sum = weights.sum
+      shares = weights.map { |weight| weight_sum.zero? ? Rational(total, weights.size) : Rational(total * weight, weight_sum) }
+      tokens = shares.map(&:floor)
+      remaining = total - tokens.sum
+      shares.each_with_index.sort_by { |share, index| [ share.floor - share, index ] }.first(remaining).each do |_, index|
+        tokens[index] += 1
+      end
+      tokens
+    end
+  end
+
   def dimensions
-    @dimensions ||= base64_decode(body[:data].first[:embedding]).unpack("f*").size
+    @dimensions ||= decode_embedding(body[:data].first).bytesize / VectorCache::BYTES_PER_DIMENSION
   end
 
   private
 
-  def base64_decode(content)
-    Base64.strict_decode64(content)
+  # upstream must return exactly one embedding per target; some providers list them out of index order
+  def validate!
+    data = body[:data]
+    unless data.is_a?(Array) && data.size == @targets.size
+      raise InvalidResponseError, "Upstream returned #{data.is_a?(Array) ? data.size : 0} embeddings for #{@targets.size} inputs"
+    end
+
+    indexes = data.map { |item| item[:index] }
+    invalid = indexes.find { |index| !index.is_a?(Integer) || !index.between?(0, @targets.size - 1) }
+    raise InvalidResponseError, "Upstream returned index #{invalid.inspect} for #{@targets.size} inputs" unless invalid.nil?
+
+    duplicates = indexes.tally.select { |_, count| count > 1 }.keys
+    return if duplicates.empty?
+
+    missing = (0...@targets.size).to_a - indexes
+    raise InvalidResponseError, "Upstream returned duplicate index #{duplicates.join(", ")} and no index #{missing.join(", ")}"
+  end
+
+  def ordered_data
+    @ordered_data ||= body[:data].sort_by { |item| item[:index] }
+  end
+
+  # returns the embedding as packed float32, whether upstream sent base64 or a float array
+  def decode_embedding(item)
+    embedding = item[:embedding]
+    binary = if embedding.is_a?(String)
+      VectorEncoding.decode_base64(embedding)
+    elsif embedding.is_a?(Array) && embedding.all? { |v| v.is_a?(Numeric) }
+      VectorEncoding.pack(embedding)
+    else
+      raise InvalidResponseError, "Upstream returned an embedding of unexpected type #{embedding.class} at index #{item[:index]}"
+    end
+    raise InvalidResponseError, "Upstream returned #{binary.bytesize} bytes of embedding at index #{item[:index]}, not a multiple of 4" unless (binary.bytesize % 4).zero?
+
+    binary
+  rescue ArgumentError
+    raise InvalidResponseError, "Upstream returned an embedding that is not valid base64 at index #{item[:index]}"
   end
 end
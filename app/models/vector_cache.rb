@@ -1,25 +1,66 @@
-require "base64"
-
 class VectorCache < ApplicationRecord
   DEFAULT_DIMENSIONS = 0
+  # content is packed as float32
+  BYTES_PER_DIMENSION = 4
+  # keeps the raw string input next to the vector so it can be re-embedded later
+  STORE_INPUT_TEXT = ENV.fetch("CACHEMBED_STORE_INPUT_TEXT", "false") == "true"
+  # applied to new entries; each row keeps the method it was stored with
+  QUANTIZATION = ENV.fetch("CACHEMBED_QUANTIZE", "none")
+  raise ArgumentError, "CACHEMBED_QUANTIZE must be one of #{VectorQuantizer::METHODS.join(", ")}, got #{QUANTIZATION}" unless VectorQuantizer::METHODS.include?(QUANTIZATION)
+  # bumping it makes every entry stored under another version unreachable, without deleting it
+  KEY_VERSION = ENV.fetch("CACHEMBED_CACHE_KEY_VERSION", "")
 
-  validates :input_hash, presence: true, uniqueness: true
+  validates :input_hash, presence: true, uniqueness: { scope: [ :model, :dimensions, :key_version, :tenant ] }
   validates :content, presence: true
   validates :model, presence: true
   validates :dimensions, presence: true
+  validates :quantization, inclusion: { in: VectorQuantizer::METHODS }
+
+  # a replaced expired entry counts as accessed, like a new one
+  before_save { self.last_accessed_at = Time.current if new_record? || will_save_change_to_content? }
+
+  scope :most_accessed, -> { order(access_count: :desc) }
+  scope :current_key_version, -> { where(key_version: KEY_VERSION) }
+  scope :expired, -> { where(expires_at: ..Time.current) }
+  scope :unexpired, -> { where(expires_at: nil).or(where(expires_at: Time.current..)) }
 
+  # an entry may already exist when the same input appears twice in a request,
+  # or when a lagging read replica reported a miss; an expired one is replaced
   def self.import_from_response!(response)
+    import_hashes!(response.vector_cache_hashes)
+  end
+
+  def self.import_hashes!(vector_cache_hashes)
+    vector_cache_hashes.map do |hash|
+      vector = find_by({ tenant: "" }.merge(hash.slice(:input_hash, :model, :dimensions, :key_version, :tenant)))
+      if vector.nil?
+        self.create!(hash)
+      elsif vector.expired?
+        vector.update!(hash.reverse_merge(expires_at: nil))
+        vector
+      else
+        vector
+      end
+    end
+  end
+
+  # builds unsaved records for responses that must not be cached
+  def self.build_from_response(response)
     response.vector_cache_hashes.map do |hash|
-      self.create!(hash)
+      new(hash)
     end
   end
 
+  def expired?
+    expires_at.present? && expires_at <= Time.current
+  end
+
   def base64_content
-    Base64.strict_encode64(content)
+    VectorEncoding.encode_base64(quantization == "none" ? content : VectorEncoding.pack(float_array_content))
   end
 
   def float_array_content
-    content.unpack("f*")
+    VectorQuantizer.dequantize(content, quantization)
   end
 
   def formatted_content(format)
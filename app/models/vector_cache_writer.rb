@@ -0,0 +1,53 @@
+# Stores upstream vectors off the request path when CACHEMBED_CACHE_WRITE_ASYNC is set.
+#
+# The queue is bounded: when it is full the request thread stores the vectors itself,
+# so writes slow down under load rather than pile up. Queued writes are flushed at exit.
+# An entry is not readable until the worker has stored it, so the same input arriving
+# right after a miss may miss again and be proxied to upstream a second time.
+class VectorCacheWriter
+  QUEUE_SIZE = ENV.fetch("CACHEMBED_CACHE_WRITE_QUEUE_SIZE", "1000").to_i
+  SHUTDOWN_TIMEOUT = 30
+
+  @mutex = Mutex.new
+  @failed_writes = Concurrent::AtomicFixnum.new
+
+  class << self
+    def enqueue(vector_cache_hashes)
+      executor.post { store(vector_cache_hashes) }
+    end
+
+    # waits for queued writes; the next enqueue starts a fresh worker
+    def flush
+      executor = @mutex.synchronize { @executor.tap { @executor = nil } }
+      return if executor.nil?
+
+      executor.shutdown
+      executor.wait_for_termination(SHUTDOWN_TIMEOUT)
+      Rails.logger.warn("#{failed_writes} background vector cache writes failed") if failed_writes.positive?
+    end
+
+    def failed_writes
+      @failed_writes.value
+    end
+
+    private
+
+    def executor
+      @mutex.synchronize do
+        @executor ||= begin
+          at_exit { flush } unless @at_exit_registered
+          @at_exit_registered = true
+          # a single worker keeps SQLite from contending with itself
+          Concurrent::ThreadPoolExecutor.new(min_threads: 1, max_threads: 1, max_queue: QUEUE_SIZE, fallback_policy: :caller_runs)
+        end
+      end
+    end
+
+    def store(vector_cache_hashes)
+      Rails.application.executor.wrap { VectorCache.import_hashes!(vector_cache_hashes) }
+    rescue StandardError => e
+      @failed_writes.increment
+      Rails.logger.error("Failed to store #{vector_cache_hashes.size} vectors in the background: #{e.message}")
+    end
+  end
+end
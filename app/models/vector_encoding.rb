@@ -0,0 +1,24 @@
+require "base64"
+
+# Converts float32 vectors to and from bytes and base64. Bytes are little-endian
+# float32, the layout of OpenAI's base64 embeddings, whatever the host byte order.
+module VectorEncoding
+  FLOAT32 = "e*"
+
+  def self.pack(floats)
+    floats.pack(FLOAT32)
+  end
+
+  def self.unpack(binary)
+    binary.unpack(FLOAT32)
+  end
+
+  def self.encode_base64(binary)
+    Base64.strict_encode64(binary)
+  end
+
+  # raises ArgumentError for anything but strict base64
+  def self.decode_base64(string)
+    Base64.strict_decode64(string)
+  end
+end
@@ -0,0 +1,77 @@
+# Converts float32 vectors (packed by VectorEncoding) to and from reduced-precision storage.
+#
+# - none:    float32 as is
+# - float16: IEEE 754 half precision, about 3 significant decimal digits
+# - int8:    a float32 scale followed by one signed byte per dimension,
+#            absolute error up to half of max(|v|) / 127
+module VectorQuantizer
+  METHODS = %w[none float16 int8].freeze
+  INT8_MAX = 127
+
+  def self.quantize(binary, method)
+    case method
+    when "none"
+      binary
+    when "float16"
+      VectorEncoding.unpack(binary).map { |v| float_to_half(v) }.pack("S<*")
+    when "int8"
+      floats = VectorEncoding.unpack(binary)
+      scale = floats.map(&:abs).max.to_f / INT8_MAX
+      values = scale.zero? ? floats.map { 0 } : floats.map { |v| (v / scale).round.clamp(-INT8_MAX, INT8_MAX) }
+      [ scale ].pack("e") + values.pack("c*")
+    else
+      raise ArgumentError, "unknown quantization: #{method}"
+    end
+  end
+
+  def self.dequantize(binary, method)
+    case method
+    when "none"
+      VectorEncoding.unpack(binary)
+    when "float16"
+      binary.unpack("S<*").map { |half| half_to_float(half) }
+    when "int8"
+      scale = binary.unpack1("e")
+      binary.byteslice(4..).unpack("c*").map { |v| v * scale }
+    else
+      raise ArgumentError, "unknown quantization: #{method}"
+    end
+  end
+
+  def self.float_to_half(value)
+    bits = [ value ].pack("e").unpack1("L<")
+    sign = (bits >> 16) & 0x8000
+    exponent = ((bits >> 23) & 0xff) - 127 + 15
+    mantissa = bits & 0x7fffff
+
+    if exponent >= 31
+      sign | 0x7c00
+    elsif exponent <= 0
+      return sign if exponent < -10
+
+      mantissa |= 0x800000
+      shift = 14 - exponent
+      half = mantissa >> shift
+      half += 1 if (mantissa >> (shift - 1)) & 1 == 1
+      sign | half
+    else
+      half = sign | (exponent << 10) | (mantissa >> 13)
+      half += 1 if mantissa & 0x1000 != 0
+      half
+    end
+  end
+
+  def self.half_to_float(half)
+    sign = (half & 0x8000).zero? ? 1.0 : -1.0
+    exponent = (half >> 10) & 0x1f
+    mantissa = half & 0x3ff
+
+    if exponent.zero?
+      sign * mantissa * 2.0**-24
+    elsif exponent == 31
+      mantissa.zero? ? sign * Float::INFINITY : Float::NAN
+    else
+      sign * (1 + mantissa / 1024.0) * 2.0**(exponent - 15)
+    end
+  end
+end
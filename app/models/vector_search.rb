@@ -0,0 +1,68 @@
+# Brute-force cosine similarity search over cached vectors of one model.
+class VectorSearch
+  include ActiveModel::Model
+
+  ENABLED = ENV.fetch("CACHEMBED_ENABLE_SEARCH", "false") == "true"
+  # searching is refused when more vectors than this would have to be scanned
+  MAX_ROWS = ENV.fetch("CACHEMBED_SEARCH_MAX_ROWS", "10000").to_i
+  # vectors loaded at a time; only the best top_k are kept between batches
+  SCAN_BATCH_SIZE = 1000
+  DEFAULT_TOP_K = 10
+  MAX_TOP_K = 100
+
+  attr_accessor :model, :embedding, :top_k, :api_key, :tenant
+
+  validates :model, presence: true, inclusion: { in: EmbeddingForm::MODEL_NAMES }
+  validates :api_key, presence: true, format: { with: EmbeddingForm::API_KEY_FORMAT }
+  validates :top_k, numericality: { only_integer: true, greater_than: 0, less_than_or_equal_to: MAX_TOP_K }
+  validate :embedding_must_be_numbers
+  validate :candidates_within_max_rows
+
+  def initialize(attributes = {})
+    super
+    self.top_k ||= DEFAULT_TOP_K
+  end
+
+  def results
+    query = embedding.map(&:to_f)
+    query_norm = norm(query)
+    best = []
+    candidates.select(:id, :input_hash, :content, :quantization).in_batches(of: SCAN_BATCH_SIZE) do |batch|
+      scored = batch.map do |vector|
+        { input_hash: vector.input_hash, similarity: cosine_similarity(query, query_norm, vector.float_array_content) }
+      end
+      best = (best + scored).max_by(top_k) { |result| result[:similarity] }
+    end
+    best
+  end
+
+  private
+
+  def candidates
+    VectorCache.current_key_version.unexpired.where(model: model, dimensions: embedding.size, tenant: tenant.to_s)
+  end
+
+  def embedding_must_be_numbers
+    return if embedding.is_a?(Array) && embedding.any? && embedding.all? { |v| v.is_a?(Numeric) }
+
+    errors.add(:embedding, "must be a non-empty array of numbers")
+  end
+
+  def candidates_within_max_rows
+    return if errors.any?
+    return if candidates.count <= MAX_ROWS
+
+    errors.add(:base, "Too many cached vectors for #{model} to search (more than #{MAX_ROWS})")
+  end
+
+  def norm(vector)
+    Math.sqrt(vector.sum { |v| v * v })
+  end
+
+  def cosine_similarity(query, query_norm, vector)
+    denominator = query_norm * norm(vector)
+    return 0.0 if denominator.zero?
+
+    query.zip(vector).sum { |a, b| a * b } / denominator
+  end
+end
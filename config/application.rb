@@ -18,6 +18,16 @@ require "rails/test_unit/railtie"
 # you've limited to :test, :development, or :production.
 Bundler.require(*Rails.groups)
 
+require_relative "../lib/cachembed/config_file"
+Cachembed::ConfigFile.load!(ENV["CACHEMBED_CONFIG"]) if ENV["CACHEMBED_CONFIG"].present?
+require_relative "../lib/cachembed/model_manifest"
+Cachembed::ModelManifest.load!(ENV["CACHEMBED_MODEL_MANIFEST"]) if ENV["CACHEMBED_MODEL_MANIFEST"].present?
+require_relative "../lib/cachembed/ip_filter"
+require_relative "../lib/cachembed/request_body_limit"
+require_relative "../lib/cachembed/gzip_request"
+require_relative "../lib/cachembed/concurrency_limit"
+require_relative "../lib/cachembed/exceptions_app"
+
 module Cachembed
   class Application < Rails::Application
     # Initialize configuration defaults for originally generated Rails version.
@@ -26,7 +36,29 @@ module Cachembed
     # Please, add to the `ignore` list any other `lib` subdirectories that do
     # not contain `.rb` files, or that should not be reloaded or eager loaded.
     # Common ones are `templates`, `generators`, or `middleware`, for example.
-    config.autoload_lib(ignore: %w[assets tasks])
+    config.autoload_lib(ignore: %w[assets tasks cachembed])
+
+    # lets several instances share one database; applies to migrations and schema_migrations too
+    config.active_record.table_name_prefix = ENV.fetch("CACHEMBED_TABLE_NAME_PREFIX", "")
+
+    # only these peers may set the client IP through X-Forwarded-For; replaces Rails' default of all private ranges
+    if ENV["CACHEMBED_TRUSTED_PROXIES"].present?
+      config.action_dispatch.trusted_proxies = ENV["CACHEMBED_TRUSTED_PROXIES"].split(",").map { |cidr| IPAddr.new(cidr.strip) }
+    end
+
+    allow_cidrs = Cachembed::IpFilter.parse(ENV["CACHEMBED_ALLOW_CIDRS"], "CACHEMBED_ALLOW_CIDRS")
+    deny_cidrs = Cachembed::IpFilter.parse(ENV["CACHEMBED_DENY_CIDRS"], "CACHEMBED_DENY_CIDRS")
+    if allow_cidrs.any? || deny_cidrs.any?
+      config.middleware.insert_after ActionDispatch::RemoteIp, Cachembed::IpFilter, allow: allow_cidrs, deny: deny_cidrs
+    end
+
+    max_concurrent_requests = ENV.fetch("CACHEMBED_MAX_CONCURRENT_REQUESTS", "0").to_i
+    config.middleware.use Cachembed::ConcurrencyLimit, max_requests: max_concurrent_requests if max_concurrent_requests.positive?
+
+    config.exceptions_app = Cachembed::ExceptionsApp.new
+
+    config.middleware.use Cachembed::RequestBodyLimit, max_bytes: ENV.fetch("CACHEMBED_MAX_REQUEST_MB", "16").to_i * 1024 * 1024
+    config.middleware.use Cachembed::GzipRequest, max_bytes: ENV.fetch("CACHEMBED_MAX_INFLATED_REQUEST_MB", "16").to_i * 1024 * 1024
 
     # Configuration for the application, engines, and railties goes here.
     #
@@ -33,12 +33,25 @@ Rails.application.configure do
   # Skip http-to-https redirect for the default health check endpoint.
   # config.ssl_options = { redirect: { exclude: ->(request) { request.path == "/up" } } }
 
-  # Log to STDOUT with the current request id as a default log tag.
+  # Log to STDOUT with the current request id as a default log tag,
+  # or to CACHEMBED_LOG_FILE, rotated by size or by day/week/month.
   config.log_tags = [ :request_id ]
-  config.logger   = ActiveSupport::TaggedLogging.logger(STDOUT)
+  config.logger = if ENV["CACHEMBED_LOG_FILE"].present?
+    shift_age = ENV.fetch("CACHEMBED_LOG_FILE_SHIFT_AGE", "5")
+    shift_age = shift_age.to_i if shift_age.match?(/\A\d+\z/)
+    begin
+      ActiveSupport::TaggedLogging.logger(ENV["CACHEMBED_LOG_FILE"], shift_age, ENV.fetch("CACHEMBED_LOG_FILE_SIZE_MB", "100").to_i.megabytes)
+    rescue SystemCallError => e
+      abort "Cannot open CACHEMBED_LOG_FILE #{ENV["CACHEMBED_LOG_FILE"]}: #{e.message}"
+    end
+  else
+    ActiveSupport::TaggedLogging.logger(STDOUT)
+  end
 
   # Change to "debug" to log everything (including potentially personally-identifiable information!)
   config.log_level = ENV.fetch("RAILS_LOG_LEVEL", "info")
+  log_levels = %w[debug info warn error fatal unknown]
+  abort "RAILS_LOG_LEVEL must be one of #{log_levels.join(", ")}, got #{config.log_level}" unless log_levels.include?(config.log_level.to_s.downcase)
 
   # Prevent health checks from clogging up the logs.
   config.silence_healthcheck_path = "/up"
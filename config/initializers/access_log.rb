@@ -0,0 +1,5 @@
+require Rails.root.join("lib/cachembed/access_log")
+
+if ENV["CACHEMBED_ACCESS_LOG"].present?
+  Cachembed::AccessLog.subscribe(ENV["CACHEMBED_ACCESS_LOG"], sample_rate: ENV.fetch("CACHEMBED_ACCESS_LOG_SAMPLE_RATE", "1").to_i)
+end
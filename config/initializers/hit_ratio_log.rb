@@ -0,0 +1,4 @@
+require Rails.root.join("lib/cachembed/hit_ratio_log")
+
+stats_interval = ENV.fetch("CACHEMBED_STATS_INTERVAL", "0").to_i
+Cachembed::HitRatioLog.subscribe(stats_interval) if stats_interval.positive?
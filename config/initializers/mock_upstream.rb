@@ -0,0 +1,5 @@
+Rails.application.config.after_initialize do
+  if MockUpstream.url?(UpstreamClient::URL)
+    Rails.logger.warn("CACHEMBED_UPSTREAM_URL is #{UpstreamClient::URL}: embeddings are FAKE and must not be used outside of tests")
+  end
+end
@@ -6,6 +6,22 @@ Rails.application.routes.draw do
   get "up" => "rails/health#show", as: :rails_health_check
   namespace :v1 do
     resources :embeddings, only: [ :create ]
+    match "embeddings", to: "embeddings#allow", via: :options
+    match "embeddings", to: "embeddings#method_not_allowed", via: :all
+    namespace :cache do
+      resource :search, only: [ :create ]
+      resources :models, only: [ :index ]
+      resource :exists, only: [ :create ], controller: "exists"
+    end
+    resource :version, only: [ :show ]
+  end
+
+  # serves the embeddings endpoint on another path too, e.g. /embeddings for OpenAI-compatible clients
+  embedding_endpoint_path = ENV["CACHEMBED_EMBEDDING_ENDPOINT_PATH"].presence
+  if embedding_endpoint_path && embedding_endpoint_path != "/v1/embeddings"
+    post embedding_endpoint_path, to: "v1/embeddings#create"
+    match embedding_endpoint_path, to: "v1/embeddings#allow", via: :options
+    match embedding_endpoint_path, to: "v1/embeddings#method_not_allowed", via: :all
   end
 
   # Render dynamic PWA files from app/views/pwa/* (remember to link manifest in application.html.erb)
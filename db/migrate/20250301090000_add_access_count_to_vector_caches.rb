@@ -0,0 +1,5 @@
+class AddAccessCountToVectorCaches < ActiveRecord::Migration[8.0]
+  def change
+    add_column :vector_caches, :access_count, :integer, null: false, default: 0
+  end
+end
@@ -0,0 +1,5 @@
+class AddInputTextToVectorCaches < ActiveRecord::Migration[8.0]
+  def change
+    add_column :vector_caches, :input_text, :text, comment: "raw input, stored only when CACHEMBED_STORE_INPUT_TEXT is enabled"
+  end
+end
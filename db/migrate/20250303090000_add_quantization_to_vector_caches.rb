@@ -0,0 +1,5 @@
+class AddQuantizationToVectorCaches < ActiveRecord::Migration[8.0]
+  def change
+    add_column :vector_caches, :quantization, :string, limit: 16, null: false, default: "none"
+  end
+end
@@ -0,0 +1,5 @@
+class AddPromptTokensToVectorCaches < ActiveRecord::Migration[8.0]
+  def change
+    add_column :vector_caches, :prompt_tokens, :integer, comment: "share of the upstream prompt_tokens for this input"
+  end
+end
@@ -0,0 +1,6 @@
+class AddExpiresAtToVectorCaches < ActiveRecord::Migration[8.0]
+  def change
+    add_column :vector_caches, :expires_at, :datetime, comment: "set from X-Cachembed-Expires-In; entries without it never expire"
+    add_index :vector_caches, :expires_at
+  end
+end
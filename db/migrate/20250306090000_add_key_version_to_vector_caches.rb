@@ -0,0 +1,7 @@
+class AddKeyVersionToVectorCaches < ActiveRecord::Migration[8.0]
+  def change
+    add_column :vector_caches, :key_version, :string, limit: 64, default: "", null: false, comment: "CACHEMBED_CACHE_KEY_VERSION the entry was stored under"
+    remove_index :vector_caches, [ :input_hash, :model, :dimensions ], unique: true
+    add_index :vector_caches, [ :input_hash, :model, :dimensions, :key_version ], unique: true, name: "index_#{proper_table_name(:vector_caches, table_name_options)}_on_cache_key"
+  end
+end
@@ -0,0 +1,7 @@
+class AddTenantToVectorCaches < ActiveRecord::Migration[8.0]
+  def change
+    add_column :vector_caches, :tenant, :string, limit: 128, default: "", null: false, comment: "value of CACHEMBED_TENANT_HEADER the entry was stored for"
+    remove_index :vector_caches, name: "index_#{proper_table_name(:vector_caches, table_name_options)}_on_cache_key"
+    add_index :vector_caches, [ :input_hash, :model, :dimensions, :key_version, :tenant ], unique: true, name: "index_#{proper_table_name(:vector_caches, table_name_options)}_on_cache_key"
+  end
+end
@@ -0,0 +1,16 @@
+class CreateIdempotentResponses < ActiveRecord::Migration[8.0]
+  def change
+    create_table :idempotent_responses do |t|
+      t.string :key_digest, null: false, limit: 64, comment: "SHA-256 of the API key, tenant and Idempotency-Key"
+      t.string :request_digest, null: false, limit: 64, comment: "SHA-256 of the request body the key was first used with"
+      t.integer :status, null: false
+      t.text :body, null: false
+      t.text :headers, comment: "X-Cachembed-* response headers, as JSON"
+      t.datetime :expires_at, null: false
+
+      t.timestamps
+      t.index :key_digest, unique: true
+      t.index :expires_at
+    end
+  end
+end
@@ -0,0 +1,10 @@
+class AddLastAccessedAtToVectorCaches < ActiveRecord::Migration[8.0]
+  def change
+    add_column :vector_caches, :last_accessed_at, :datetime, comment: "set when stored and on every cache hit; cachembed:gc FIELD=accessed keys on it"
+    add_index :vector_caches, :last_accessed_at
+    # hits never touched updated_at, so it is the closest existing record of the last access
+    up_only do
+      execute "UPDATE #{connection.quote_table_name(proper_table_name(:vector_caches, table_name_options))} SET last_accessed_at = updated_at"
+    end
+  end
+end
@@ -0,0 +1,11 @@
+class ChangeIdempotentResponsesBodyLimit < ActiveRecord::Migration[8.0]
+  # a plain text column is 64 KB on MySQL, smaller than most embeddings responses;
+  # above 16 MB it becomes LONGTEXT there, and the limit is ignored on SQLite and PostgreSQL
+  def up
+    change_column :idempotent_responses, :body, :text, limit: 16.megabytes, null: false
+  end
+
+  def down
+    change_column :idempotent_responses, :body, :text, null: false
+  end
+end
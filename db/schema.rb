@@ -10,13 +10,13 @@
 #
 # It's strongly recommended that you check this file into your version control system.
 
-ActiveRecord::Schema[8.0].define(version: 2025_02_16_082236) do
+ActiveRecord::Schema[8.0].define(version: 2025_03_10_090000) do
   create_table "embedding_models", force: :cascade do |t|
     t.string "name", limit: 256, null: false
     t.integer "default_dimensions", null: false
     t.datetime "created_at", null: false
     t.datetime "updated_at", null: false
-    t.index ["name"], name: "index_embedding_models_on_name", unique: true
+    t.index ["name"], unique: true
   end
 
   create_table "embedding_requests", force: :cascade do |t|
@@ -26,7 +26,20 @@ ActiveRecord::Schema[8.0].define(version: 2025_02_16_082236) do
     t.string "model", limit: 255, null: false
     t.datetime "created_at", null: false
     t.datetime "updated_at", null: false
-    t.index ["created_at"], name: "index_embedding_requests_on_created_at"
+    t.index ["created_at"]
+  end
+
+  create_table "idempotent_responses", force: :cascade do |t|
+    t.string "key_digest", limit: 64, null: false, comment: "SHA-256 of the API key, tenant and Idempotency-Key"
+    t.string "request_digest", limit: 64, null: false, comment: "SHA-256 of the request body the key was first used with"
+    t.integer "status", null: false
+    t.text "body", limit: 16777216, null: false
+    t.text "headers", comment: "X-Cachembed-* response headers, as JSON"
+    t.datetime "expires_at", null: false
+    t.datetime "created_at", null: false
+    t.datetime "updated_at", null: false
+    t.index ["expires_at"]
+    t.index ["key_digest"], unique: true
   end
 
   create_table "vector_caches", force: :cascade do |t|
@@ -36,6 +49,16 @@ ActiveRecord::Schema[8.0].define(version: 2025_02_16_082236) do
     t.binary "content", null: false
     t.datetime "created_at", null: false
     t.datetime "updated_at", null: false
-    t.index ["input_hash", "model", "dimensions"], name: "index_vector_caches_on_input_hash_and_model_and_dimensions", unique: true
+    t.integer "access_count", default: 0, null: false
+    t.text "input_text", comment: "raw input, stored only when CACHEMBED_STORE_INPUT_TEXT is enabled"
+    t.string "quantization", limit: 16, default: "none", null: false
+    t.integer "prompt_tokens", comment: "share of the upstream prompt_tokens for this input"
+    t.datetime "expires_at", comment: "set from X-Cachembed-Expires-In; entries without it never expire"
+    t.string "key_version", limit: 64, default: "", null: false, comment: "CACHEMBED_CACHE_KEY_VERSION the entry was stored under"
+    t.string "tenant", limit: 128, default: "", null: false, comment: "value of CACHEMBED_TENANT_HEADER the entry was stored for"
+    t.datetime "last_accessed_at", comment: "set when stored and on every cache hit; cachembed:gc FIELD=accessed keys on it"
+    t.index ["expires_at"]
+    t.index ["input_hash", "model", "dimensions", "key_version", "tenant"], name: "index_#{proper_table_name("vector_caches", table_name_options)}_on_cache_key", unique: true
+    t.index ["last_accessed_at"]
   end
 end
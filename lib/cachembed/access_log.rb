@@ -0,0 +1,58 @@
+require "json"
+
+module Cachembed
+  # Writes one JSON line per request, apart from the application log, so it can be
+  # shipped and retained on its own terms. Set CACHEMBED_ACCESS_LOG to a path, or "-" for stdout.
+  # With CACHEMBED_ACCESS_LOG_SAMPLE_RATE=N only every Nth successful request is written;
+  # responses with status 400 or above are always written.
+  class AccessLog
+    def self.subscribe(path, sample_rate: 1)
+      raise ArgumentError, "CACHEMBED_ACCESS_LOG_SAMPLE_RATE must be a positive integer, got #{sample_rate}" unless sample_rate.positive?
+
+      io = path == "-" ? $stdout : File.open(path, "a")
+      io.sync = true
+      access_log = new(io, sample_rate: sample_rate)
+      ActiveSupport::Notifications.subscribe("process_action.action_controller") { |event| access_log.write(event) }
+    end
+
+    def initialize(io, sample_rate: 1)
+      @io = io
+      @sample_rate = sample_rate
+      @successes = Concurrent::AtomicFixnum.new
+    end
+
+    def write(event)
+      payload = event.payload
+      status = status(payload)
+      return if skipped?(status)
+
+      @io.puts({
+        time: Time.current.utc.iso8601(3),
+        method: payload[:method],
+        path: payload[:path],
+        client_ip: payload[:remote_ip],
+        status: status,
+        duration_ms: event.duration.round(1),
+        db_runtime_ms: payload[:db_runtime]&.round(1),
+        bytes: payload[:response]&.body&.bytesize,
+        cache_hits: payload[:cache_hits],
+        cache_misses: payload[:cache_misses]
+      }.compact.to_json)
+    end
+
+    private
+
+    def skipped?(status)
+      return false if @sample_rate == 1 || status.nil? || status >= 400
+
+      (@successes.increment - 1) % @sample_rate != 0
+    end
+
+    # unhandled exceptions have no status yet; report what the exception will be rendered as
+    def status(payload)
+      return payload[:status] if payload[:status]
+
+      ActionDispatch::ExceptionWrapper.status_code_for_exception(payload[:exception]&.first) if payload[:exception]
+    end
+  end
+end
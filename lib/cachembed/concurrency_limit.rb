@@ -0,0 +1,40 @@
+require "concurrent"
+require "json"
+require "rack/body_proxy"
+
+module Cachembed
+  # Answers 503 with Retry-After once max_requests are in flight in this process, so a slow
+  # upstream or database pushes back on clients instead of queueing work without bound.
+  # A slot is held until the response body is closed.
+  class ConcurrencyLimit
+    RETRY_AFTER_SECONDS = 1
+
+    def initialize(app, max_requests:)
+      @app = app
+      @semaphore = Concurrent::Semaphore.new(max_requests)
+      @max_requests = max_requests
+    end
+
+    def call(env)
+      return overloaded unless @semaphore.try_acquire
+
+      begin
+        status, headers, body = @app.call(env)
+      rescue
+        @semaphore.release
+        raise
+      end
+      [ status, headers, Rack::BodyProxy.new(body) { @semaphore.release } ]
+    end
+
+    private
+
+    def overloaded
+      [
+        503,
+        { "content-type" => "application/json", "retry-after" => RETRY_AFTER_SECONDS.to_s },
+        [ { errors: [ "Too many concurrent requests, the limit is #{@max_requests}" ] }.to_json ]
+      ]
+    end
+  end
+end
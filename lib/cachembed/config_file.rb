@@ -0,0 +1,75 @@
+require "yaml"
+
+module Cachembed
+  # Loads settings from a YAML file into ENV before the application reads them.
+  # Environment variables that are already set take precedence over the file.
+  class ConfigFile
+    class Error < StandardError; end
+
+    # CACHEMBED_VALIDATE is not here: bin/docker-entrypoint reads it before Ruby starts
+    KEYS = {
+      "upstream_url" => "CACHEMBED_UPSTREAM_URL",
+      "upstream_encoding_format" => "CACHEMBED_UPSTREAM_ENCODING_FORMAT",
+      "upstream_pool_size" => "CACHEMBED_UPSTREAM_POOL_SIZE",
+      "upstream_idle_timeout" => "CACHEMBED_UPSTREAM_IDLE_TIMEOUT",
+      "embedding_endpoint_path" => "CACHEMBED_EMBEDDING_ENDPOINT_PATH",
+      "allowed_models" => "CACHEMBED_ALLOWED_MODELS",
+      "api_key_pattern" => "CACHEMBED_API_KEY_PATTERN",
+      "strict_model_dimension" => "CACHEMBED_STRICT_MODEL_DIMENSION",
+      "model_dimensions" => "CACHEMBED_MODEL_DIMENSIONS",
+      "model_max_tokens" => "CACHEMBED_MODEL_MAX_TOKENS",
+      "model_manifest" => "CACHEMBED_MODEL_MANIFEST",
+      "require_dimensions" => "CACHEMBED_REQUIRE_DIMENSIONS",
+      "max_cached_input_length" => "CACHEMBED_MAX_CACHED_INPUT_LENGTH",
+      "admin_token" => "CACHEMBED_ADMIN_TOKEN",
+      "max_request_mb" => "CACHEMBED_MAX_REQUEST_MB",
+      "max_inflated_request_mb" => "CACHEMBED_MAX_INFLATED_REQUEST_MB",
+      "max_concurrent_requests" => "CACHEMBED_MAX_CONCURRENT_REQUESTS",
+      "cache_key_version" => "CACHEMBED_CACHE_KEY_VERSION",
+      "tenant_header" => "CACHEMBED_TENANT_HEADER",
+      "access_log" => "CACHEMBED_ACCESS_LOG",
+      "access_log_sample_rate" => "CACHEMBED_ACCESS_LOG_SAMPLE_RATE",
+      "stats_interval" => "CACHEMBED_STATS_INTERVAL",
+      "trusted_proxies" => "CACHEMBED_TRUSTED_PROXIES",
+      "allow_cidrs" => "CACHEMBED_ALLOW_CIDRS",
+      "deny_cidrs" => "CACHEMBED_DENY_CIDRS",
+      "log_file" => "CACHEMBED_LOG_FILE",
+      "log_file_shift_age" => "CACHEMBED_LOG_FILE_SHIFT_AGE",
+      "log_file_size_mb" => "CACHEMBED_LOG_FILE_SIZE_MB",
+      "db_failure_mode" => "CACHEMBED_DB_FAILURE_MODE",
+      "table_name_prefix" => "CACHEMBED_TABLE_NAME_PREFIX",
+      "db_schema" => "CACHEMBED_DB_SCHEMA",
+      "cache_write_async" => "CACHEMBED_CACHE_WRITE_ASYNC",
+      "cache_write_queue_size" => "CACHEMBED_CACHE_WRITE_QUEUE_SIZE",
+      "cache_only_models" => "CACHEMBED_CACHE_ONLY_MODELS",
+      "idempotency_key_ttl" => "CACHEMBED_IDEMPOTENCY_KEY_TTL",
+      "warn_on_large_vectors" => "CACHEMBED_WARN_ON_LARGE_VECTORS",
+      "store_input_text" => "CACHEMBED_STORE_INPUT_TEXT",
+      "enable_search" => "CACHEMBED_ENABLE_SEARCH",
+      "search_max_rows" => "CACHEMBED_SEARCH_MAX_ROWS",
+      "quantize" => "CACHEMBED_QUANTIZE",
+      "usage_mode" => "CACHEMBED_USAGE_MODE",
+      "no_touch" => "CACHEMBED_NO_TOUCH",
+      "database_url" => "DATABASE_URL",
+      "read_database_url" => "CACHEMBED_READ_DATABASE_URL"
+    }.freeze
+
+    def self.load!(path, env = ENV)
+      settings = YAML.safe_load_file(path) || {}
+      raise Error, "#{path}: expected a mapping of settings" unless settings.is_a?(Hash)
+
+      unknown = settings.keys.map(&:to_s) - KEYS.keys
+      raise Error, "#{path}: unknown settings: #{unknown.join(", ")}" if unknown.any?
+
+      settings.each do |key, value|
+        name = KEYS.fetch(key.to_s)
+        next if value.nil? || env.key?(name)
+
+        # lists such as allowed_models may be written as YAML arrays
+        env[name] = value.is_a?(Array) ? value.join(",") : value.to_s
+      end
+    rescue Psych::Exception, Errno::ENOENT => e
+      raise Error, "#{path}: #{e.message}"
+    end
+  end
+end
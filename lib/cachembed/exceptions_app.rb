@@ -0,0 +1,32 @@
+require "json"
+
+module Cachembed
+  # Renders 404s in the OpenAI error format, so SDKs pointed at a wrong path
+  # report a readable error instead of failing to parse an HTML page.
+  # Other statuses keep Rails' public error pages.
+  class ExceptionsApp
+    def initialize(fallback = nil)
+      @fallback = fallback
+    end
+
+    def call(env)
+      return fallback.call(env) unless env["PATH_INFO"] == "/404"
+
+      request = ActionDispatch::Request.new(env)
+      message = if env["action_dispatch.exception"].is_a?(ActionController::RoutingError)
+        "Unknown request URL: #{request.request_method} #{env["action_dispatch.original_path"]}"
+      else
+        "Not found"
+      end
+      body = { error: { message: message, type: "invalid_request_error", param: nil, code: "not_found" } }
+      [ 404, { "content-type" => "application/json; charset=utf-8" }, [ body.to_json ] ]
+    end
+
+    private
+
+    # Rails.public_path is not known yet when config/application.rb runs
+    def fallback
+      @fallback ||= ActionDispatch::PublicExceptions.new(Rails.public_path)
+    end
+  end
+end
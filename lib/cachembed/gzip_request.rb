@@ -0,0 +1,49 @@
+require "json"
+require "stringio"
+require "zlib"
+
+module Cachembed
+  # Inflates request bodies sent with Content-Encoding: gzip before Rails parses them.
+  # The inflated size is capped, so a small compressed body cannot expand without bound.
+  class GzipRequest
+    class TooLarge < StandardError; end
+
+    def initialize(app, max_bytes:)
+      @app = app
+      @max_bytes = max_bytes
+    end
+
+    def call(env)
+      return @app.call(env) unless env["HTTP_CONTENT_ENCODING"].to_s.strip.casecmp?("gzip") && env["rack.input"]
+
+      begin
+        body = inflate(env["rack.input"])
+      rescue Zlib::Error
+        return error(400, "Request body is not valid gzip")
+      rescue TooLarge
+        return error(413, "Request body exceeds #{@max_bytes} bytes when inflated")
+      end
+
+      env.delete("HTTP_CONTENT_ENCODING")
+      env["rack.input"] = StringIO.new(body)
+      env["CONTENT_LENGTH"] = body.bytesize.to_s
+      @app.call(env)
+    end
+
+    private
+
+    def inflate(input)
+      reader = Zlib::GzipReader.new(input)
+      body = reader.read(@max_bytes + 1) || +""
+      raise TooLarge if body.bytesize > @max_bytes
+
+      # reading to the end verifies the gzip footer checksum
+      reader.finish
+      body
+    end
+
+    def error(status, message)
+      [ status, { "content-type" => "application/json" }, [ { errors: [ message ] }.to_json ] ]
+    end
+  end
+end
@@ -0,0 +1,58 @@
+module Cachembed
+  # Logs one line every interval with the cache hits and misses this process
+  # served since the previous line. Set CACHEMBED_STATS_INTERVAL to the interval
+  # in seconds. Each Puma worker reports its own counts; the timer starts with
+  # the first counted request, after the worker has been forked.
+  class HitRatioLog
+    def self.subscribe(interval, logger: Rails.logger)
+      hit_ratio_log = new(interval, logger)
+      ActiveSupport::Notifications.subscribe("process_action.action_controller") { |event| hit_ratio_log.record(event.payload) }
+      hit_ratio_log
+    end
+
+    def initialize(interval, logger)
+      @interval = interval
+      @logger = logger
+      @hits = Concurrent::AtomicFixnum.new
+      @misses = Concurrent::AtomicFixnum.new
+      @mutex = Mutex.new
+    end
+
+    def record(payload)
+      return if payload[:cache_hits].nil? && payload[:cache_misses].nil?
+
+      start_timer
+      @hits.increment(payload[:cache_hits].to_i)
+      @misses.increment(payload[:cache_misses].to_i)
+    end
+
+    # logs the counts since the previous call and resets them; nothing is logged for an idle interval
+    def flush
+      hits = take(@hits)
+      misses = take(@misses)
+      return if hits + misses == 0
+
+      @logger.info("Cache hit ratio over the last #{@interval}s: #{(hits.to_f / (hits + misses)).round(4)} (#{hits} hits, #{misses} misses, pid #{Process.pid})")
+    end
+
+    private
+
+    # requests counted while reading stay in the counter for the next interval
+    def take(counter)
+      value = counter.value
+      counter.decrement(value)
+      value
+    end
+
+    def start_timer
+      return if @timer_pid == Process.pid
+
+      @mutex.synchronize do
+        next if @timer_pid == Process.pid
+
+        @timer = Concurrent::TimerTask.execute(execution_interval: @interval) { flush }
+        @timer_pid = Process.pid
+      end
+    end
+  end
+end
@@ -0,0 +1,48 @@
+require "ipaddr"
+require "json"
+
+module Cachembed
+  # Rejects requests by client IP before they reach the application.
+  # Runs after ActionDispatch::RemoteIp, so CACHEMBED_TRUSTED_PROXIES decides which
+  # address is checked. A denied address is always rejected; when an allowlist is
+  # given, addresses outside it are rejected too.
+  class IpFilter
+    def self.parse(cidrs, name)
+      cidrs.to_s.split(",").map(&:strip).reject(&:empty?).map do |cidr|
+        IPAddr.new(cidr)
+      rescue IPAddr::Error => e
+        raise ArgumentError, "#{name} has an invalid CIDR #{cidr}: #{e.message}"
+      end
+    end
+
+    def initialize(app, allow:, deny:)
+      @app = app
+      @allow = allow
+      @deny = deny
+    end
+
+    def call(env)
+      ip = client_ip(env)
+      return @app.call(env) if permitted?(ip)
+
+      Rails.logger.warn("Blocked request from #{ip || "unknown address"}")
+      [ 403, { "content-type" => "application/json" }, [ { errors: [ "Forbidden" ] }.to_json ] ]
+    end
+
+    private
+
+    def client_ip(env)
+      remote_ip = env["action_dispatch.remote_ip"]&.to_s || env["REMOTE_ADDR"]
+      IPAddr.new(remote_ip).native
+    rescue IPAddr::Error
+      nil
+    end
+
+    def permitted?(ip)
+      return false if ip.nil?
+      return false if @deny.any? { |cidr| cidr.include?(ip) }
+
+      @allow.empty? || @allow.any? { |cidr| cidr.include?(ip) }
+    end
+  end
+end
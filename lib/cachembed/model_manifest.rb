@@ -0,0 +1,67 @@
+require "yaml"
+
+module Cachembed
+  # Loads per-model policy from one YAML file into the environment variables it replaces:
+  #
+  #   text-embedding-3-small:
+  #     dimensions: 2..1536
+  #     max_tokens: 8191
+  #   text-embedding-ada-002:
+  #     max_tokens: 8191
+  #
+  # Every listed model is allowed. Models without dimensions reject the dimensions parameter.
+  class ModelManifest
+    class Error < StandardError; end
+
+    SETTINGS = %w[dimensions max_tokens].freeze
+    REPLACED = %w[CACHEMBED_ALLOWED_MODELS CACHEMBED_MODEL_DIMENSIONS CACHEMBED_MODEL_MAX_TOKENS CACHEMBED_STRICT_MODEL_DIMENSION].freeze
+
+    def self.load!(path, env = ENV)
+      conflicts = REPLACED.select { |name| env.key?(name) }
+      raise Error, "#{path}: the model manifest replaces #{conflicts.join(", ")}; unset them" if conflicts.any?
+
+      manifest = parse(path)
+      env["CACHEMBED_ALLOWED_MODELS"] = manifest.keys.join(",")
+      env["CACHEMBED_MODEL_DIMENSIONS"] = manifest.filter_map { |model, policy| "#{model}:#{policy[:dimensions]}" if policy[:dimensions] }.join(",")
+      env["CACHEMBED_MODEL_MAX_TOKENS"] = manifest.filter_map { |model, policy| "#{model}:#{policy[:max_tokens]}" if policy[:max_tokens] }.join(",")
+      env["CACHEMBED_STRICT_MODEL_DIMENSION"] = "true"
+    end
+
+    # returns { model => { dimensions: "min..max", max_tokens: Integer } }, raising Error on any problem
+    def self.parse(path)
+      models = YAML.safe_load_file(path)
+      raise Error, "#{path}: expected a mapping of model names" unless models.is_a?(Hash) && models.any?
+
+      models.to_h do |model, policy|
+        policy ||= {}
+        raise Error, "#{path}: #{model}: expected a mapping of settings" unless policy.is_a?(Hash)
+        raise Error, "#{path}: #{model}: model names must not contain ',' or ':'" if model.to_s.match?(/[,:]/) || model.to_s.empty?
+
+        unknown = policy.keys.map(&:to_s) - SETTINGS
+        raise Error, "#{path}: #{model}: unknown settings: #{unknown.join(", ")}" if unknown.any?
+
+        [ model.to_s, { dimensions: parse_dimensions(path, model, policy["dimensions"]), max_tokens: parse_max_tokens(path, model, policy["max_tokens"]) } ]
+      end
+    rescue Psych::Exception, Errno::ENOENT => e
+      raise Error, "#{path}: #{e.message}"
+    end
+
+    def self.parse_dimensions(path, model, value)
+      return if value.nil?
+
+      min, max = value.is_a?(Integer) ? [ 1, value ] : value.to_s.split("..", 2).map { |bound| Integer(bound, exception: false) }
+      raise Error, "#{path}: #{model}: dimensions must be a maximum or a min..max range, got #{value}" unless min&.positive? && max && min <= max
+
+      "#{min}..#{max}"
+    end
+
+    def self.parse_max_tokens(path, model, value)
+      return if value.nil?
+      raise Error, "#{path}: #{model}: max_tokens must be a positive integer, got #{value}" unless value.is_a?(Integer) && value.positive?
+
+      value
+    end
+
+    private_class_method :parse_dimensions, :parse_max_tokens
+  end
+end
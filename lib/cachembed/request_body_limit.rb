@@ -0,0 +1,37 @@
+require "json"
+require "stringio"
+
+module Cachembed
+  # Answers 413 for request bodies larger than max_bytes, as sent, before Rails parses them.
+  # Puma accepts bodies of any size, so without this a single request can exhaust memory.
+  # Bodies without Content-Length (chunked) are read up to the limit and buffered.
+  class RequestBodyLimit
+    def initialize(app, max_bytes:)
+      @app = app
+      @max_bytes = max_bytes
+    end
+
+    def call(env)
+      input = env["rack.input"]
+      return @app.call(env) unless input
+
+      length = env["CONTENT_LENGTH"]
+      if length.present?
+        return too_large if length.to_i > @max_bytes
+      else
+        body = input.read(@max_bytes + 1) || +""
+        return too_large if body.bytesize > @max_bytes
+
+        env["rack.input"] = StringIO.new(body)
+        env["CONTENT_LENGTH"] = body.bytesize.to_s
+      end
+      @app.call(env)
+    end
+
+    private
+
+    def too_large
+      [ 413, { "content-type" => "application/json" }, [ { errors: [ "Request body exceeds #{@max_bytes} bytes" ] }.to_json ] ]
+    end
+  end
+end
@@ -0,0 +1,33 @@
+require 'rails_helper'
+require 'open3'
+
+RSpec.describe "db/schema.rb" do
+  # loads the schema in a separate process, since the prefix is read when the app boots
+  def load_schema(database_path, prefix)
+    env = {
+      "RAILS_ENV" => "test",
+      "DATABASE_URL" => "sqlite3:#{database_path}",
+      "CACHEMBED_TABLE_NAME_PREFIX" => prefix,
+      "CACHEMBED_READ_DATABASE_URL" => nil,
+      "DISABLE_DATABASE_ENVIRONMENT_CHECK" => "1"
+    }
+    Open3.capture2e(env, Rails.root.join("bin/rails").to_s, "db:schema:load", chdir: Rails.root.to_s)
+  end
+
+  it 'テーブル名のプレフィックスが異なる2つのインスタンスが同じデータベースにスキーマを読み込めること' do
+    Dir.mktmpdir do |dir|
+      database_path = File.join(dir, "shared.sqlite3")
+
+      %w[first_ second_].each do |prefix|
+        output, status = load_schema(database_path, prefix)
+        expect(status).to be_success, output
+      end
+
+      db = SQLite3::Database.new(database_path)
+      indexes = db.execute("SELECT name FROM sqlite_master WHERE type = 'index' AND name LIKE 'index_%'").flatten
+      db.close
+      expect(indexes).to include("index_first_vector_caches_on_cache_key", "index_second_vector_caches_on_cache_key")
+      expect(indexes).to include("index_first_embedding_models_on_name", "index_second_embedding_models_on_name")
+    end
+  end
+end
@@ -0,0 +1,53 @@
+require 'rails_helper'
+
+RSpec.describe Cachembed::AccessLog do
+  let(:io) { StringIO.new }
+  let(:access_log) { described_class.new(io) }
+
+  def event(payload)
+    ActiveSupport::Notifications::Event.new("process_action.action_controller", Time.current, Time.current + 0.012, "id", payload)
+  end
+
+  describe '#write' do
+    it 'リクエストごとに1行のJSONを書き込むこと' do
+      access_log.write(event(method: "POST", path: "/v1/embeddings", remote_ip: "203.0.113.7", status: 200, cache_hits: 1, cache_misses: 2))
+
+      line = JSON.parse(io.string.lines.sole)
+      expect(line).to include("method" => "POST", "path" => "/v1/embeddings", "client_ip" => "203.0.113.7", "status" => 200, "cache_hits" => 1, "cache_misses" => 2)
+    end
+
+    it 'データベースの処理時間を記録すること' do
+      access_log.write(event(method: "POST", path: "/v1/embeddings", status: 200, db_runtime: 3.456))
+
+      expect(JSON.parse(io.string)["db_runtime_ms"]).to eq(3.5)
+    end
+
+    it '処理されなかった例外のステータスを記録すること' do
+      access_log.write(event(method: "POST", path: "/v1/embeddings", exception: [ "ActiveRecord::RecordNotFound", "Not found" ]))
+
+      expect(JSON.parse(io.string)["status"]).to eq(404)
+    end
+  end
+
+  describe 'サンプリング' do
+    let(:access_log) { described_class.new(io, sample_rate: 3) }
+
+    it '成功したリクエストはN件に1件だけ書き込むこと' do
+      6.times { access_log.write(event(method: "POST", path: "/v1/embeddings", status: 200)) }
+
+      expect(io.string.lines.size).to eq(2)
+    end
+
+    it 'ステータスが400以上のリクエストは常に書き込むこと' do
+      access_log.write(event(method: "POST", path: "/v1/embeddings", status: 200))
+      3.times { access_log.write(event(method: "POST", path: "/v1/embeddings", status: 429)) }
+      access_log.write(event(method: "POST", path: "/v1/embeddings", exception: [ "RuntimeError", "boom" ]))
+
+      expect(io.string.lines.map { |line| JSON.parse(line)["status"] }).to eq([ 200, 429, 429, 429, 500 ])
+    end
+
+    it '0以下のサンプリングレートはエラーとなること' do
+      expect { described_class.subscribe("-", sample_rate: 0) }.to raise_error(ArgumentError, "CACHEMBED_ACCESS_LOG_SAMPLE_RATE must be a positive integer, got 0")
+    end
+  end
+end
@@ -0,0 +1,47 @@
+require 'rails_helper'
+
+RSpec.describe Cachembed::ConcurrencyLimit do
+  let(:app) { ->(_env) { [ 200, {}, [ "ok" ] ] } }
+  let(:middleware) { described_class.new(app, max_requests: 2) }
+
+  describe '#call' do
+    context '処理中のリクエストが上限に達している場合' do
+      let(:started) { Queue.new }
+      let(:finish) { Queue.new }
+      let(:app) do
+        lambda do |_env|
+          started << true
+          finish.pop
+          [ 200, {}, [ "ok" ] ]
+        end
+      end
+
+      it '503とRetry-Afterを返すこと' do
+        threads = Array.new(2) { Thread.new { middleware.call({}).tap { |response| response[2].close } } }
+        2.times { started.pop }
+
+        status, headers, body = middleware.call({})
+        expect(status).to eq(503)
+        expect(headers["retry-after"]).to eq("1")
+        expect(JSON.parse(body.first)).to eq({ "errors" => [ "Too many concurrent requests, the limit is 2" ] })
+
+        2.times { finish << true }
+        expect(threads.map { |thread| thread.value[0] }).to eq([ 200, 200 ])
+        expect(middleware.call({})[0]).to eq(200)
+      end
+    end
+
+    it 'レスポンスのボディを閉じるまで枠を保持すること' do
+      responses = Array.new(2) { middleware.call({}) }
+      expect(middleware.call({})[0]).to eq(503)
+
+      responses.first[2].close
+      expect(middleware.call({})[0]).to eq(200)
+    end
+
+    it '例外が発生しても枠を返すこと' do
+      failing = described_class.new(->(_env) { raise "boom" }, max_requests: 1)
+      2.times { expect { failing.call({}) }.to raise_error("boom") }
+    end
+  end
+end
@@ -0,0 +1,65 @@
+require 'rails_helper'
+
+RSpec.describe Cachembed::ConfigFile do
+  let(:env) { {} }
+  let(:file) { Tempfile.new([ "cachembed", ".yml" ]) }
+
+  after { file.close! }
+
+  def write(content)
+    file.write(content)
+    file.flush
+  end
+
+  describe '.load!' do
+    it '設定を環境変数に読み込むこと' do
+      write(<<~YAML)
+        upstream_url: http://localhost:8080/v1/embeddings
+        allowed_models:
+          - text-embedding-3-small
+          - text-embedding-3-large
+        strict_model_dimension: true
+      YAML
+
+      described_class.load!(file.path, env)
+      expect(env).to eq(
+        "CACHEMBED_UPSTREAM_URL" => "http://localhost:8080/v1/embeddings",
+        "CACHEMBED_ALLOWED_MODELS" => "text-embedding-3-small,text-embedding-3-large",
+        "CACHEMBED_STRICT_MODEL_DIMENSION" => "true"
+      )
+    end
+
+    it '既に設定されている環境変数を優先すること' do
+      env["CACHEMBED_UPSTREAM_URL"] = "http://override/v1/embeddings"
+      write("upstream_url: http://localhost:8080/v1/embeddings\n")
+
+      described_class.load!(file.path, env)
+      expect(env["CACHEMBED_UPSTREAM_URL"]).to eq("http://override/v1/embeddings")
+    end
+
+    it '未知のキーがある場合はエラーを発生させること' do
+      write("upstream_uri: http://localhost:8080\n")
+
+      expect { described_class.load!(file.path, env) }
+        .to raise_error(Cachembed::ConfigFile::Error, /unknown settings: upstream_uri/)
+    end
+
+    it 'エントリポイントが読むvalidateは設定ファイルでは受け付けないこと' do
+      write("validate: true\n")
+
+      expect { described_class.load!(file.path, env) }
+        .to raise_error(Cachembed::ConfigFile::Error, /unknown settings: validate/)
+      expect(env).to be_empty
+    end
+
+    it '不正なYAMLの場合はエラーを発生させること' do
+      write("upstream_url: [\n")
+
+      expect { described_class.load!(file.path, env) }.to raise_error(Cachembed::ConfigFile::Error)
+    end
+
+    it 'ファイルが存在しない場合はエラーを発生させること' do
+      expect { described_class.load!("/nonexistent/cachembed.yml", env) }.to raise_error(Cachembed::ConfigFile::Error)
+    end
+  end
+end
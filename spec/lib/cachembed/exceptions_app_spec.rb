@@ -0,0 +1,35 @@
+require 'rails_helper'
+
+RSpec.describe Cachembed::ExceptionsApp do
+  let(:fallback) { ->(_env) { [ 500, { "content-type" => "text/html" }, [ "<html></html>" ] ] } }
+  let(:app) { described_class.new(fallback) }
+
+  def env_for(status, exception:)
+    Rack::MockRequest.env_for("/#{status}", method: "POST").merge(
+      "action_dispatch.exception" => exception,
+      "action_dispatch.original_path" => "/v1/embedding"
+    )
+  end
+
+  describe '#call' do
+    it '存在しないパスへのリクエストにOpenAI形式の404を返すこと' do
+      status, headers, body = app.call(env_for(404, exception: ActionController::RoutingError.new("No route matches")))
+
+      expect(status).to eq(404)
+      expect(headers["content-type"]).to start_with("application/json")
+      expect(JSON.parse(body.join)).to eq(
+        "error" => { "message" => "Unknown request URL: POST /v1/embedding", "type" => "invalid_request_error", "param" => nil, "code" => "not_found" }
+      )
+    end
+
+    it 'ルーティング以外の404にも同じ形式を返すこと' do
+      _, _, body = app.call(env_for(404, exception: ActiveRecord::RecordNotFound.new))
+
+      expect(JSON.parse(body.join)["error"]).to include("message" => "Not found", "code" => "not_found")
+    end
+
+    it '404以外はRailsのエラーページに任せること' do
+      expect(app.call(env_for(500, exception: RuntimeError.new))).to eq([ 500, { "content-type" => "text/html" }, [ "<html></html>" ] ])
+    end
+  end
+end
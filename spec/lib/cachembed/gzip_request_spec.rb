@@ -0,0 +1,41 @@
+require 'rails_helper'
+
+RSpec.describe Cachembed::GzipRequest do
+  let(:app) { ->(env) { [ 200, {}, [ env["rack.input"].read ] ] } }
+  let(:middleware) { described_class.new(app, max_bytes: 16) }
+
+  def call(body, encoding: "gzip")
+    middleware.call("HTTP_CONTENT_ENCODING" => encoding, "rack.input" => StringIO.new(body))
+  end
+
+  describe '#call' do
+    it 'gzipされたボディを展開すること' do
+      status, _headers, body = call(ActiveSupport::Gzip.compress('{"input":"a"}'))
+      expect(status).to eq(200)
+      expect(body).to eq([ '{"input":"a"}' ])
+    end
+
+    it 'gzip以外のボディはそのまま渡すこと' do
+      _status, _headers, body = call('{"input":"a"}', encoding: "identity")
+      expect(body).to eq([ '{"input":"a"}' ])
+    end
+
+    it '不正なgzipは400を返すこと' do
+      status, _headers, body = call("not gzip")
+      expect(status).to eq(400)
+      expect(JSON.parse(body.first)).to eq({ "errors" => [ "Request body is not valid gzip" ] })
+    end
+
+    it 'アプリ内のZlib::Errorは400にせずそのまま発生させること' do
+      failing = described_class.new(->(_env) { raise Zlib::DataError, "from the app" }, max_bytes: 16)
+      expect {
+        failing.call("HTTP_CONTENT_ENCODING" => "gzip", "rack.input" => StringIO.new(ActiveSupport::Gzip.compress("{}")))
+      }.to raise_error(Zlib::DataError, "from the app")
+    end
+
+    it '展開後の上限を超えると413を返すこと' do
+      status, _headers, _body = call(ActiveSupport::Gzip.compress("a" * 17))
+      expect(status).to eq(413)
+    end
+  end
+end
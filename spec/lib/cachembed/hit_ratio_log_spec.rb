@@ -0,0 +1,44 @@
+require 'rails_helper'
+
+RSpec.describe Cachembed::HitRatioLog do
+  let(:io) { StringIO.new }
+  let(:hit_ratio_log) { described_class.new(60, Logger.new(io)) }
+
+  before do
+    allow(Concurrent::TimerTask).to receive(:execute)
+  end
+
+  describe '#flush' do
+    it '前回からのヒット数、ミス数とヒット率を1行で記録すること' do
+      hit_ratio_log.record(cache_hits: 3, cache_misses: 1)
+      hit_ratio_log.record(cache_hits: 0, cache_misses: 0)
+      hit_ratio_log.flush
+
+      expect(io.string.lines.sole).to include("Cache hit ratio over the last 60s: 0.75 (3 hits, 1 misses, pid #{Process.pid})")
+    end
+
+    it '記録後にカウンタをリセットすること' do
+      hit_ratio_log.record(cache_hits: 1, cache_misses: 0)
+      hit_ratio_log.flush
+      hit_ratio_log.record(cache_hits: 0, cache_misses: 2)
+      hit_ratio_log.flush
+
+      expect(io.string.lines.last).to include("0.0 (0 hits, 2 misses")
+    end
+
+    it 'キャッシュを使わないリクエストだけの間は何も記録しないこと' do
+      hit_ratio_log.record(status: 200)
+      hit_ratio_log.flush
+
+      expect(io.string).to be_empty
+    end
+  end
+
+  describe '#record' do
+    it 'プロセスごとに一度だけタイマーを開始すること' do
+      2.times { hit_ratio_log.record(cache_hits: 1, cache_misses: 0) }
+
+      expect(Concurrent::TimerTask).to have_received(:execute).with(execution_interval: 60).once
+    end
+  end
+end
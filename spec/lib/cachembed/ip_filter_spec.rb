@@ -0,0 +1,44 @@
+require 'rails_helper'
+
+RSpec.describe Cachembed::IpFilter do
+  let(:app) { ->(_env) { [ 200, {}, [ "OK" ] ] } }
+
+  def status_for(ip, allow: [], deny: [])
+    filter = described_class.new(app, allow: described_class.parse(allow.join(","), "ALLOW"), deny: described_class.parse(deny.join(","), "DENY"))
+    filter.call("action_dispatch.remote_ip" => ip).first
+  end
+
+  describe '#call' do
+    it '許可リストに含まれるアドレスのみ通すこと' do
+      expect(status_for("10.0.0.5", allow: [ "10.0.0.0/8" ])).to eq(200)
+      expect(status_for("192.168.0.5", allow: [ "10.0.0.0/8" ])).to eq(403)
+    end
+
+    it '拒否リストを許可リストより優先すること' do
+      expect(status_for("10.0.0.5", allow: [ "10.0.0.0/8" ], deny: [ "10.0.0.0/24" ])).to eq(403)
+      expect(status_for("10.0.1.5", allow: [ "10.0.0.0/8" ], deny: [ "10.0.0.0/24" ])).to eq(200)
+    end
+
+    it 'IPv6アドレスを扱えること' do
+      expect(status_for("2001:db8::1", allow: [ "2001:db8::/32" ])).to eq(200)
+      expect(status_for("2001:db9::1", allow: [ "2001:db8::/32" ])).to eq(403)
+    end
+
+    it 'IPv4射影アドレスをIPv4として扱うこと' do
+      expect(status_for("::ffff:10.0.0.5", allow: [ "10.0.0.0/8" ])).to eq(200)
+    end
+
+    it '拒否したリクエストにJSONのエラーを返すこと' do
+      filter = described_class.new(app, allow: [], deny: described_class.parse("0.0.0.0/0", "DENY"))
+      _status, headers, body = filter.call("action_dispatch.remote_ip" => "10.0.0.5")
+      expect(headers["content-type"]).to eq("application/json")
+      expect(JSON.parse(body.first)).to eq({ "errors" => [ "Forbidden" ] })
+    end
+  end
+
+  describe '.parse' do
+    it '不正なCIDRはエラーになること' do
+      expect { described_class.parse("10.0.0.0/8,nonsense", "CACHEMBED_ALLOW_CIDRS") }.to raise_error(ArgumentError, /CACHEMBED_ALLOW_CIDRS has an invalid CIDR nonsense/)
+    end
+  end
+end
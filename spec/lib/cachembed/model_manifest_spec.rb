@@ -0,0 +1,63 @@
+require 'rails_helper'
+
+RSpec.describe Cachembed::ModelManifest do
+  let(:env) { {} }
+  let(:file) { Tempfile.new([ "models", ".yml" ]) }
+
+  after { file.close! }
+
+  def write(content)
+    file.write(content)
+    file.flush
+  end
+
+  describe '.load!' do
+    it 'マニフェストを既存の環境変数に展開すること' do
+      write(<<~YAML)
+        text-embedding-3-small:
+          dimensions: 2..1536
+          max_tokens: 8191
+        text-embedding-3-large:
+          dimensions: 3072
+        text-embedding-ada-002:
+          max_tokens: 8191
+      YAML
+
+      described_class.load!(file.path, env)
+      expect(env).to eq(
+        "CACHEMBED_ALLOWED_MODELS" => "text-embedding-3-small,text-embedding-3-large,text-embedding-ada-002",
+        "CACHEMBED_MODEL_DIMENSIONS" => "text-embedding-3-small:2..1536,text-embedding-3-large:2..3072",
+        "CACHEMBED_MODEL_MAX_TOKENS" => "text-embedding-3-small:8191,text-embedding-ada-002:8191",
+        "CACHEMBED_STRICT_MODEL_DIMENSION" => "true"
+      )
+    end
+
+    it '置き換える環境変数が設定されている場合はエラーになること' do
+      write("text-embedding-3-small:\n")
+      env["CACHEMBED_ALLOWED_MODELS"] = "text-embedding-3-small"
+      expect { described_class.load!(file.path, env) }.to raise_error(described_class::Error, /replaces CACHEMBED_ALLOWED_MODELS/)
+    end
+  end
+
+  describe '.parse' do
+    it '不明な設定はエラーになること' do
+      write("text-embedding-3-small:\n  dimension: 256\n")
+      expect { described_class.parse(file.path) }.to raise_error(described_class::Error, /unknown settings: dimension/)
+    end
+
+    it '不正なdimensionsはエラーになること' do
+      write("text-embedding-3-small:\n  dimensions: 1536..1\n")
+      expect { described_class.parse(file.path) }.to raise_error(described_class::Error, /dimensions must be/)
+    end
+
+    it '不正なmax_tokensはエラーになること' do
+      write("text-embedding-3-small:\n  max_tokens: -1\n")
+      expect { described_class.parse(file.path) }.to raise_error(described_class::Error, /max_tokens must be a positive integer/)
+    end
+
+    it '空のマニフェストはエラーになること' do
+      write("")
+      expect { described_class.parse(file.path) }.to raise_error(described_class::Error, /expected a mapping of model names/)
+    end
+  end
+end
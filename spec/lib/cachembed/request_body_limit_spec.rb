@@ -0,0 +1,31 @@
+require 'rails_helper'
+
+RSpec.describe Cachembed::RequestBodyLimit do
+  let(:app) { ->(env) { [ 200, {}, [ env["rack.input"].read ] ] } }
+  let(:middleware) { described_class.new(app, max_bytes: 16) }
+
+  describe '#call' do
+    it '上限以下のボディはそのまま渡すこと' do
+      status, _headers, body = middleware.call("CONTENT_LENGTH" => "13", "rack.input" => StringIO.new('{"input":"a"}'))
+      expect(status).to eq(200)
+      expect(body).to eq([ '{"input":"a"}' ])
+    end
+
+    it 'Content-Lengthが上限を超えると読まずに413を返すこと' do
+      input = StringIO.new("a" * 17)
+      status, _headers, body = middleware.call("CONTENT_LENGTH" => "17", "rack.input" => input)
+      expect(status).to eq(413)
+      expect(input.pos).to eq(0)
+      expect(JSON.parse(body.first)).to eq({ "errors" => [ "Request body exceeds 16 bytes" ] })
+    end
+
+    it 'Content-Lengthのないボディは上限まで読んで判定すること' do
+      status, _headers, _body = middleware.call("rack.input" => StringIO.new("a" * 17))
+      expect(status).to eq(413)
+
+      status, _headers, body = middleware.call("rack.input" => StringIO.new("a" * 16))
+      expect(status).to eq(200)
+      expect(body).to eq([ "a" * 16 ])
+    end
+  end
+end
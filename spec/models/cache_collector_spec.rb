@@ -0,0 +1,149 @@
+require 'rails_helper'
+
+RSpec.describe CacheCollector do
+  let(:io) { StringIO.new }
+  let(:sleeps) { [] }
+  let(:sleeper) { ->(seconds) { sleeps << seconds } }
+
+  def create_entry(input_hash, created_at:, last_accessed_at: created_at)
+    VectorCache.create!(input_hash: input_hash, content: "AAAA", model: "text-embedding-3-small", dimensions: 256, created_at: created_at)
+      .tap { |entry| entry.update_columns(last_accessed_at: last_accessed_at) }
+  end
+
+  def collector(**options)
+    described_class.new(before: 30.days, io: io, sleeper: sleeper, **options)
+  end
+
+  describe '#run' do
+    it '閾値より前に作成されたエントリのみ削除すること' do
+      create_entry("a" * 40, created_at: 31.days.ago)
+      create_entry("b" * 40, created_at: 29.days.ago)
+
+      instance = collector
+      expect(instance.run).to eq(1)
+      expect(instance.stopped_early?).to be(false)
+      expect(VectorCache.pluck(:input_hash)).to eq([ "b" * 40 ])
+    end
+
+    it 'バッチごとに削除し、削除した行があったバッチの後だけ待機すること' do
+      5.times { |i| create_entry(i.to_s * 40, created_at: 40.days.ago) }
+
+      expect(collector(batch_size: 2, sleep_seconds: 0.5).run).to eq(5)
+      expect(sleeps).to eq([ 0.5, 0.5, 0.5 ])
+      expect(io.string.lines.size).to eq(4)
+      expect(io.string).to include("rows/sec")
+    end
+
+    it '古いidが削除済みでも空のバッチで待機せずに進むこと' do
+      stale = Array.new(3) { |i| create_entry(i.to_s * 40, created_at: 40.days.ago) }
+      VectorCache.where(id: stale.first(2).map(&:id)).delete_all
+
+      expect(collector(batch_size: 1, sleep_seconds: 1).run).to eq(1)
+      expect(sleeps).to eq([ 1 ])
+    end
+
+    it '最大idの古いエントリも削除すること' do
+      create_entry("a" * 40, created_at: 1.day.ago)
+      newest = create_entry("b" * 40, created_at: 40.days.ago)
+      expect(VectorCache.maximum(:id)).to eq(newest.id)
+
+      expect(collector.run).to eq(1)
+      expect(VectorCache.exists?(newest.id)).to be(false)
+    end
+
+    it 'PostgreSQLとMySQLではバッチのidをSKIP LOCKEDで行ロックして取得すること' do
+      create_entry("a" * 40, created_at: 40.days.ago)
+      statements = []
+      counter = ->(_name, _start, _finish, _id, payload) { statements << payload[:sql] }
+
+      ActiveSupport::Notifications.subscribed(counter, "sql.active_record") { collector.run }
+      selects = statements.grep(/\ASELECT .*#{VectorCache.table_name}/i)
+      if VectorCache.connection.adapter_name.match?(/sqlite/i)
+        expect(selects).to all(satisfy { |sql| !sql.include?("FOR UPDATE") })
+      else
+        expect(selects).to all(include("FOR UPDATE SKIP LOCKED"))
+      end
+    end
+
+    it '制限時間を過ぎた場合は現在のバッチを終えて停止し、再開位置を出力すること' do
+      stale = Array.new(3) { |i| create_entry(i.to_s * 40, created_at: 40.days.ago) }
+
+      instance = collector(batch_size: 1, max_duration: 0)
+      expect(instance.run).to eq(1)
+      expect(instance.stopped_early?).to be(true)
+      expect(io.string.lines.last).to eq("stopped early after 1 entries, last processed id #{stale.first.id}; resume with START_ID=#{stale.first.id + 1}\n")
+      expect(VectorCache.count).to eq(2)
+    end
+
+    it '停止を要求された場合は現在のバッチを終えて停止すること' do
+      2.times { |i| create_entry(i.to_s * 40, created_at: 40.days.ago) }
+      instance = collector(batch_size: 1)
+      instance.stop!
+
+      expect(instance.run).to eq(1)
+      expect(io.string).to include("stopped early")
+    end
+
+    it 'start_idより前のエントリは削除しないこと' do
+      stale = Array.new(3) { |i| create_entry(i.to_s * 40, created_at: 40.days.ago) }
+
+      expect(collector(start_id: stale[1].id).run).to eq(2)
+      expect(VectorCache.pluck(:id)).to eq([ stale.first.id ])
+    end
+
+    it '負のstart_idはエラーとなること' do
+      expect { collector(start_id: -1) }.to raise_error(ArgumentError, "START_ID must not be negative, got -1")
+    end
+
+    it 'created の場合は最近アクセスされたエントリも作成日時で削除すること' do
+      create_entry("a" * 40, created_at: 40.days.ago, last_accessed_at: 1.day.ago)
+
+      expect(collector(field: "created").run).to eq(1)
+    end
+
+    it 'accessed の場合は最終アクセス日時で削除すること' do
+      create_entry("a" * 40, created_at: 40.days.ago, last_accessed_at: 1.day.ago)
+      create_entry("b" * 40, created_at: 40.days.ago, last_accessed_at: 31.days.ago)
+
+      expect(collector(field: "accessed").run).to eq(1)
+      expect(VectorCache.pluck(:input_hash)).to eq([ "a" * 40 ])
+    end
+
+    it '未対応のfieldはエラーとなること' do
+      expect { collector(field: "updated") }.to raise_error(ArgumentError, "FIELD must be one of created, accessed, got updated")
+    end
+    # CI runs this on SQLite, PostgreSQL and MySQL; datetime columns hold UTC on all of them
+    it '保存したエントリを時間経過後に削除し、経過前には削除しないこと' do
+      entry = VectorCache.create!(input_hash: "a" * 40, content: "AAAA", model: "text-embedding-3-small", dimensions: 256)
+
+      travel 29.days do
+        expect(collector.run).to eq(0)
+      end
+      travel 31.days do
+        expect(collector.run).to eq(1)
+        expect(collector(field: "accessed").run).to eq(0)
+      end
+      expect(VectorCache.exists?(entry.id)).to be(false)
+    end
+  end
+
+  describe '.parse_duration' do
+    {
+      "90s" => 90.seconds,
+      "15m" => 15.minutes,
+      "12h" => 12.hours,
+      "30d" => 30.days,
+      "4w" => 4.weeks
+    }.each do |value, duration|
+      it "#{value}を#{duration.inspect}と解釈すること" do
+        expect(described_class.parse_duration(value)).to eq(duration)
+      end
+    end
+
+    [ "-24h", "0d", "0", "3600", "", "thirty", "30 d", "1.5h", "30y" ].each do |value|
+      it "#{value.inspect}はエラーとなり、値をメッセージに含めること" do
+        expect { described_class.parse_duration(value) }.to raise_error(ArgumentError, /got #{Regexp.escape(value.inspect)}\z/)
+      end
+    end
+  end
+end
@@ -0,0 +1,54 @@
+require 'rails_helper'
+
+RSpec.describe CacheEraser do
+  let(:text) { "Hello, world!" }
+  let(:input_hash) { EmbeddingTarget.new(text).sha1sum }
+
+  before do
+    VectorCache.create!(input_hash: input_hash, content: "AAAA", model: "text-embedding-3-small", dimensions: 256)
+    VectorCache.create!(input_hash: input_hash, content: "AAAA", model: "text-embedding-3-small", dimensions: 1536)
+    VectorCache.create!(input_hash: input_hash, content: "AAAA", model: "text-embedding-3-large", dimensions: 3072)
+    VectorCache.create!(input_hash: EmbeddingTarget.new("other").sha1sum, content: "AAAA", model: "text-embedding-3-small", dimensions: 256)
+    EmbeddingRequest.create!(input_hash: input_hash, input_length: text.bytesize, model: "text-embedding-3-small")
+  end
+
+  describe '#erase' do
+    it 'すべてのモデルと次元から該当するエントリを削除すること' do
+      result = described_class.new.erase(text)
+      expect(result.found).to eq(3)
+      expect(result.deleted).to eq(3)
+      expect(VectorCache.where(input_hash: input_hash)).to be_empty
+      expect(EmbeddingRequest.where(input_hash: input_hash)).to be_empty
+      expect(VectorCache.count).to eq(1)
+    end
+
+    it 'モデルを指定した場合はそのモデルのエントリのみ削除すること' do
+      result = described_class.new(model: "text-embedding-3-small").erase(text)
+      expect(result.deleted).to eq(2)
+      expect(VectorCache.where(input_hash: input_hash).pluck(:model)).to eq([ "text-embedding-3-large" ])
+    end
+
+    it '該当するエントリがない場合は0件を返すこと' do
+      result = described_class.new.erase("unknown")
+      expect(result.found).to eq(0)
+      expect(result.deleted).to eq(0)
+    end
+
+    it 'match_substringの場合は保存された入力テキストの部分一致で削除すること' do
+      VectorCache.create!(input_hash: "c" * 40, content: "AAAA", model: "text-embedding-3-small", dimensions: 256, input_text: "Say Hello, world! twice")
+      result = described_class.new(match_substring: true).erase(text)
+      expect(result.deleted).to eq(1)
+      expect(VectorCache.where(input_hash: "c" * 40)).to be_empty
+    end
+
+    it 'match_substringの場合は一致したエントリのリクエストログも同じトランザクションで削除すること' do
+      VectorCache.create!(input_hash: "c" * 40, content: "AAAA", model: "text-embedding-3-small", dimensions: 256, input_text: "Say Hello, world! twice")
+      EmbeddingRequest.create!(input_hash: "c" * 40, input_length: 23, model: "text-embedding-3-small")
+      EmbeddingRequest.create!(input_hash: "d" * 40, input_length: 5, model: "text-embedding-3-small")
+
+      expect(ActiveRecord::Base).to receive(:transaction).and_call_original
+      described_class.new(match_substring: true).erase(text)
+      expect(EmbeddingRequest.pluck(:input_hash)).to eq([ "d" * 40 ])
+    end
+  end
+end
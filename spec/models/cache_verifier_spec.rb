@@ -0,0 +1,62 @@
+require 'rails_helper'
+
+RSpec.describe CacheVerifier do
+  before do
+    VectorCache.create!(
+      input_hash: Digest::SHA1.hexdigest("same"),
+      content: [ 0.125, 0.25, 0.5 ].pack("f*"),
+      model: "text-embedding-3-small",
+      dimensions: 3,
+      input_text: "same"
+    )
+    VectorCache.create!(
+      input_hash: Digest::SHA1.hexdigest("drifted"),
+      content: [ 0.125, 0.25, 0.5 ].pack("f*"),
+      model: "text-embedding-3-small",
+      dimensions: 3,
+      input_text: "drifted"
+    )
+    stub_request(:post, UpstreamClient::URL)
+      .with(body: hash_including(model: "text-embedding-3-small", dimensions: 3))
+      .to_return do |request|
+        inputs = JSON.parse(request.body)["input"]
+        vectors = { "same" => [ 0.125, 0.25, 0.5 ], "drifted" => [ 0.5, -0.25, 0.0 ] }
+        {
+          status: 200,
+          headers: { 'Content-Type' => 'application/json' },
+          body: {
+            data: inputs.map.with_index { |input, index| { object: "embedding", embedding: Base64.strict_encode64(vectors.fetch(input).pack("f*")), index: index } },
+            model: "text-embedding-3-small",
+            usage: { prompt_tokens: 2, total_tokens: 2 }
+          }.to_json
+        }
+      end
+  end
+
+  subject(:verifier) { described_class.new(model: "text-embedding-3-small", api_key: "sk-abc123") }
+
+  describe '#run' do
+    it '再取得したベクトルとの類似度が閾値を下回るエントリを検出すること' do
+      results = verifier.run
+      expect(results.size).to eq(2)
+      mismatches = results.select { |result| verifier.mismatch?(result) }
+      expect(mismatches.map(&:input_hash)).to eq([ Digest::SHA1.hexdigest("drifted") ])
+      expect(results.find { |result| result.input_hash == Digest::SHA1.hexdigest("same") }.similarity).to be_within(1e-6).of(1.0)
+    end
+
+    it 'SAMPLE件数までしか取得しないこと' do
+      results = described_class.new(model: "text-embedding-3-small", api_key: "sk-abc123", sample_size: 1).run
+      expect(results.size).to eq(1)
+    end
+
+    it 'データベースで無作為に抽出し、SAMPLE件数のidだけを読み込むこと' do
+      statements = []
+      counter = ->(_name, _start, _finish, _id, payload) { statements << payload[:sql] }
+      ActiveSupport::Notifications.subscribed(counter, "sql.active_record") do
+        described_class.new(model: "text-embedding-3-small", api_key: "sk-abc123", sample_size: 1).run
+      end
+
+      expect(statements).to include(a_string_matching(/ORDER BY RAND(OM)?\(\) LIMIT/i))
+    end
+  end
+end
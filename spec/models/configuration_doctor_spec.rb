@@ -0,0 +1,84 @@
+require 'rails_helper'
+
+RSpec.describe ConfigurationDoctor do
+  let(:io) { StringIO.new }
+
+  describe '#run' do
+    it 'デフォルト設定ではすべてのチェックに合格すること' do
+      doctor = described_class.new
+      expect(doctor.run(io)).to be true
+      expect(io.string).to include("[PASS] database is reachable")
+      expect(io.string).to include("[PASS] migrations are current")
+      expect(io.string).to include("[PASS] API key pattern rejects an empty key")
+      expect(io.string).to include("[PASS] tables exist")
+      expect(io.string).to include("[PASS] cache lookup index exists")
+      expect(io.string).not_to include("[FAIL]")
+    end
+
+    it 'キャッシュのエントリ数を表示すること' do
+      VectorCache.create!(input_hash: "a" * 40, content: "AAAA", model: "text-embedding-ada-002", dimensions: 1)
+      described_class.new.run(io)
+      expect(io.string).to include("[PASS] cache entries: 1")
+    end
+
+    it 'テーブルがない場合は不合格になること' do
+      allow(ActiveRecord::Base.connection).to receive(:table_exists?).and_call_original
+      allow(ActiveRecord::Base.connection).to receive(:table_exists?).with(VectorCache.table_name).and_return(false)
+      doctor = described_class.new
+      expect(doctor.run(io)).to be false
+      expect(io.string).to include("[FAIL] tables exist: missing #{VectorCache.table_name}")
+    end
+
+    context 'CACHEMBED_API_KEY_PATTERNが設定されている場合' do
+      around do |example|
+        ENV["CACHEMBED_API_KEY_PATTERN"] = pattern
+        example.run
+      ensure
+        ENV.delete("CACHEMBED_API_KEY_PATTERN")
+      end
+
+      context '空文字列にマッチする場合' do
+        let(:pattern) { ".*" }
+
+        it '不合格になること' do
+          doctor = described_class.new
+          expect(doctor.run(io)).to be false
+          expect(io.string).to include("[FAIL] API key pattern rejects an empty key")
+        end
+      end
+
+      context 'コンパイルできない場合' do
+        let(:pattern) { "[" }
+
+        it '例外を出さずに不合格になること' do
+          doctor = described_class.new
+          expect(doctor.run(io)).to be false
+          expect(io.string).to include("[FAIL] API key pattern compiles")
+        end
+      end
+    end
+
+    it '許可されたモデルが空の場合は不合格になること' do
+      stub_const("EmbeddingForm::MODEL_NAMES", [])
+      doctor = described_class.new
+      expect(doctor.run(io)).to be false
+      expect(io.string).to include("[FAIL] allowed models is not empty")
+    end
+
+    context 'upstreamを確認する場合' do
+      it '応答があれば合格になること' do
+        stub_request(:head, UpstreamClient::URL).to_return(status: 405)
+        doctor = described_class.new(probe_upstream: true)
+        expect(doctor.run(io)).to be true
+        expect(io.string).to include("[PASS] upstream is reachable")
+      end
+
+      it '接続できなければ不合格になること' do
+        stub_request(:head, UpstreamClient::URL).to_timeout
+        doctor = described_class.new(probe_upstream: true)
+        expect(doctor.run(io)).to be false
+        expect(io.string).to include("[FAIL] upstream is reachable")
+      end
+    end
+  end
+end
@@ -0,0 +1,66 @@
+require 'rails_helper'
+
+RSpec.describe DatabaseVacuum do
+  let(:io) { StringIO.new }
+  let(:connection) { ActiveRecord::Base.connection }
+
+  before do
+    allow(connection).to receive(:adapter_name).and_return("SQLite")
+    allow(connection).to receive(:execute).and_call_original
+    allow(connection).to receive(:execute).with(/\A(PRAGMA wal_checkpoint|PRAGMA incremental_vacuum|VACUUM)/)
+  end
+
+  def vacuum(**options)
+    described_class.new(io: io, **options)
+  end
+
+  describe '#run' do
+    it 'SQLiteではWALを切り詰めてからVACUUMし、前後のサイズを出力すること' do
+      instance = vacuum
+      allow(instance).to receive(:database_bytes).and_return(4096, 4096, 1024)
+      allow(instance).to receive(:free_bytes).and_return(1_000_000)
+
+      instance.run
+
+      expect(connection).to have_received(:execute).with("PRAGMA wal_checkpoint(TRUNCATE)").ordered
+      expect(connection).to have_received(:execute).with("VACUUM").ordered
+      expect(io.string).to eq("database and WAL files: 4096 bytes before, 1024 bytes after\n")
+    end
+
+    it '空き容量が足りない場合はVACUUMせずにエラーとなること' do
+      instance = vacuum
+      allow(instance).to receive(:database_bytes).and_return(4096)
+      allow(instance).to receive(:free_bytes).and_return(1024)
+
+      expect { instance.run }.to raise_error(DatabaseVacuum::InsufficientSpaceError, /4096 bytes free.*only 1024/)
+      expect(connection).not_to have_received(:execute).with("VACUUM")
+    end
+
+    it '空き容量が取得できない場合はVACUUMすること' do
+      instance = vacuum
+      allow(instance).to receive(:database_bytes).and_return(4096)
+      allow(instance).to receive(:free_bytes).and_return(nil)
+
+      instance.run
+
+      expect(connection).to have_received(:execute).with("VACUUM")
+    end
+
+    it 'incrementalの場合はincremental_vacuumを実行すること' do
+      instance = vacuum(incremental: true)
+      allow(instance).to receive(:database_bytes).and_return(4096)
+      allow(connection).to receive(:select_value).with("PRAGMA auto_vacuum").and_return(2)
+
+      instance.run
+
+      expect(connection).to have_received(:execute).with("PRAGMA incremental_vacuum")
+      expect(connection).not_to have_received(:execute).with("VACUUM")
+    end
+
+    it '未対応のアダプタではエラーとなること' do
+      allow(connection).to receive(:adapter_name).and_return("Oracle")
+
+      expect { vacuum.run }.to raise_error(ArgumentError, /not supported on Oracle/)
+    end
+  end
+end
@@ -0,0 +1,45 @@
+require 'rails_helper'
+
+RSpec.describe EmbeddingBenchmark do
+  before do
+    stub_const("UpstreamClient::URL", "mock://deterministic?dim=4&seed=bench")
+  end
+
+  def benchmark(**options)
+    described_class.new(requests: 20, concurrency: 2, input_size: 50, hit_ratio: 0.5, model: "text-embedding-3-small", api_key: "sk-bench", seed: 1, **options)
+  end
+
+  describe '#run' do
+    it 'すべてのリクエストのレイテンシとSQLの実行数を返すこと' do
+      result = benchmark.run
+
+      expect(result.errors).to eq(0)
+      expect(result.latencies.size).to eq(20)
+      expect(result.percentile(50)).to be <= result.percentile(99)
+      expect(result.reads).to be_positive
+      expect(result.writes).to be_positive
+      expect(result.to_h).to include(requests: 20, concurrency: 2, hit_ratio: 0.5)
+    end
+
+    it 'ヒット率1の場合はウォームアップ後に上流へリクエストしないこと' do
+      upstream = MockUpstream.new(UpstreamClient::URL)
+      allow(MockUpstream).to receive(:new).and_return(upstream)
+      allow(upstream).to receive(:embed).and_call_original
+
+      benchmark(hit_ratio: 1.0).run
+
+      expect(upstream).to have_received(:embed).exactly(EmbeddingBenchmark::HOT_INPUTS).times
+    end
+
+    it '作成したエントリとリクエストログを削除すること' do
+      benchmark.run
+
+      expect(VectorCache.count).to eq(0)
+      expect(EmbeddingRequest.count).to eq(0)
+    end
+
+    it '範囲外のヒット率はエラーとなること' do
+      expect { benchmark(hit_ratio: 1.5) }.to raise_error(ArgumentError, "HIT_RATIO must be between 0 and 1, got 1.5")
+    end
+  end
+end
@@ -1,6 +1,8 @@
 require 'rails_helper'
 
 RSpec.describe EmbeddingForm do
+  RSpec::Matchers.define_negated_matcher :not_change, :change
+
   let(:valid_attributes) do
     {
       model: "text-embedding-ada-002",
@@ -39,9 +41,10 @@ RSpec.describe EmbeddingForm do
       expect(form.errors[:api_key]).to include("is invalid")
     end
 
-    it 'inputが空配列の場合はtargetsが1つの要素を持つこと' do
-      form = EmbeddingForm.new(valid_attributes.merge(input: []))
-      expect(form.targets.size).to eq(1)
+    it 'inputが空配列の場合はエラーを発生させること' do
+      expect {
+        EmbeddingForm.new(valid_attributes.merge(input: []))
+      }.to raise_error(EmbeddingTarget::InvalidInputError, "input must not be empty")
     end
 
     context 'dimensionsのバリデーション' do
@@ -51,6 +54,11 @@ RSpec.describe EmbeddingForm do
         expect(form.errors[:dimensions]).to include("must be greater than 1")
       end
 
+      it '2の場合は有効であること' do
+        form = EmbeddingForm.new(valid_attributes.merge(dimensions: 2))
+        expect(form).to be_valid
+      end
+
       it '10000以上の場合は無効であること' do
         form = EmbeddingForm.new(valid_attributes.merge(dimensions: 10000))
         form.valid?
@@ -61,6 +69,92 @@ RSpec.describe EmbeddingForm do
         form = EmbeddingForm.new(valid_attributes.merge(dimensions: nil))
         expect(form).to be_valid
       end
+
+      it 'REQUIRE_DIMENSIONSが有効な場合、nilは無効であること' do
+        stub_const("EmbeddingForm::REQUIRE_DIMENSIONS", true)
+        form = EmbeddingForm.new(valid_attributes.merge(dimensions: nil))
+        form.valid?
+        expect(form.errors[:dimensions]).to include("can't be blank")
+      end
+
+      it 'REQUIRE_DIMENSIONSが有効な場合、指定されていれば有効であること' do
+        stub_const("EmbeddingForm::REQUIRE_DIMENSIONS", true)
+        form = EmbeddingForm.new(valid_attributes.merge(dimensions: 256))
+        expect(form).to be_valid
+      end
+
+      it '文字列の場合は無効であること' do
+        form = EmbeddingForm.new(valid_attributes.merge(dimensions: "256"))
+        form.valid?
+        expect(form.errors[:dimensions]).to include("must be an integer, not string")
+      end
+    end
+
+    context 'モデルごとのdimensionsのバリデーション' do
+      before do
+        stub_const("EmbeddingForm::STRICT_MODEL_DIMENSION", true)
+      end
+
+      it 'モデルの範囲内の場合は有効であること' do
+        form = EmbeddingForm.new(valid_attributes.merge(model: "text-embedding-3-small", dimensions: 256))
+        expect(form).to be_valid
+      end
+
+      it 'モデルの範囲外の場合は無効であること' do
+        form = EmbeddingForm.new(valid_attributes.merge(model: "text-embedding-3-small", dimensions: 3072))
+        form.valid?
+        expect(form.errors[:dimensions]).to include("must be between 2 and 1536 for text-embedding-3-small")
+      end
+
+      it 'モデルの範囲の下限は有効であること' do
+        form = EmbeddingForm.new(valid_attributes.merge(model: "text-embedding-3-small", dimensions: 2))
+        expect(form).to be_valid
+      end
+
+      it '下限より小さい場合は同じ下限のエラーになること' do
+        form = EmbeddingForm.new(valid_attributes.merge(model: "text-embedding-3-small", dimensions: 1))
+        form.valid?
+        expect(form.errors[:dimensions]).to include("must be greater than 1", "must be between 2 and 1536 for text-embedding-3-small")
+      end
+
+      it 'dimensionsに対応していないモデルの場合は無効であること' do
+        form = EmbeddingForm.new(valid_attributes.merge(dimensions: 256))
+        form.valid?
+        expect(form.errors[:dimensions]).to include("is not supported by text-embedding-ada-002")
+      end
+
+      it 'STRICT_MODEL_DIMENSIONが無効の場合は範囲外でも有効であること' do
+        stub_const("EmbeddingForm::STRICT_MODEL_DIMENSION", false)
+        form = EmbeddingForm.new(valid_attributes.merge(model: "text-embedding-3-small", dimensions: 3072))
+        expect(form).to be_valid
+      end
+    end
+
+    context 'トークン数のバリデーション' do
+      before do
+        stub_const("EmbeddingForm::MODEL_MAX_TOKENS", { "text-embedding-ada-002" => 3 })
+      end
+
+      it '上限以下のトークン配列の場合は有効であること' do
+        form = EmbeddingForm.new(valid_attributes.merge(input: [ [ 1, 2, 3 ], [ 4 ] ]))
+        expect(form).to be_valid
+      end
+
+      it '上限を超えるトークン配列の場合は無効であること' do
+        form = EmbeddingForm.new(valid_attributes.merge(input: [ [ 1 ], [ 1, 2, 3, 4 ] ]))
+        form.valid?
+        expect(form.errors[:input]).to include("at index 1 has 4 tokens, exceeding the maximum of 3 for text-embedding-ada-002")
+      end
+
+      it '文字列入力はトークン数を検証しないこと' do
+        form = EmbeddingForm.new(valid_attributes.merge(input: [ "a long text input" ]))
+        expect(form).to be_valid
+      end
+
+      it '上限が設定されていないモデルは検証しないこと' do
+        form = EmbeddingForm.new(valid_attributes.merge(model: "text-embedding-3-small", input: [ [ 1, 2, 3, 4 ] ]))
+        expect(form).to be_valid
+      end
     end
 
     context 'encoding_formatのバリデーション' do
@@ -77,6 +171,72 @@ RSpec.describe EmbeddingForm do
     end
   end
 
+  describe '.api_key_format' do
+    it 'パターン全体に一致する正規表現を返すこと' do
+      expect(EmbeddingForm.api_key_format("sk-[a-z]+")).to match("sk-abc")
+      expect(EmbeddingForm.api_key_format("sk-[a-z]+")).not_to match("xsk-abc")
+    end
+
+    it 'コンパイルできないパターンはパターンを含むエラーとなること' do
+      expect { EmbeddingForm.api_key_format("sk-[") }.to raise_error(ArgumentError, /\ACACHEMBED_API_KEY_PATTERN must be a valid regular expression, got "sk-\["/)
+    end
+  end
+
+  describe '#save! のusage' do
+    let(:cached_texts) { [ "cached1", "cached2" ] }
+    let(:fresh_texts) { [ "fresh1", "fresh2" ] }
+    let(:form) do
+      EmbeddingForm.new(valid_attributes.merge(input: [ "cached1", "fresh1", "cached2", "fresh2" ]))
+    end
+
+    before do
+      EmbeddingModel.create!(name: "text-embedding-ada-002", default_dimensions: 3)
+      cached_texts.zip([ 3, 5 ]).each do |text, tokens|
+        VectorCache.create!(
+          input_hash: Digest::SHA1.hexdigest(text),
+          content: Base64.strict_decode64("AAAAPgAAgD4AAAA/"),
+          model: "text-embedding-ada-002",
+          dimensions: 3,
+          prompt_tokens: tokens
+        )
+      end
+      stub_request(:post, "https://api.openai.com/v1/embeddings")
+        .with(body: { model: "text-embedding-ada-002", input: fresh_texts, encoding_format: "base64" })
+        .to_return(
+          status: 200,
+          headers: { 'Content-Type' => 'application/json' },
+          body: {
+            data: fresh_texts.map.with_index { |_, index| { object: "embedding", embedding: "AADAPgAAQD8AAGA/", index: index } },
+            model: "text-embedding-ada-002",
+            usage: { prompt_tokens: 8, total_tokens: 8 }
+          }.to_json
+        )
+    end
+
+    it 'upstreamの場合はupstreamのusageを返すこと' do
+      stub_const("EmbeddingForm::USAGE_MODE", "upstream")
+      form.save!
+      expect([ form.prompt_tokens, form.total_tokens ]).to eq([ 8, 8 ])
+    end
+
+    it 'zero-on-hitの場合はキャッシュヒットがあれば0を返すこと' do
+      stub_const("EmbeddingForm::USAGE_MODE", "zero-on-hit")
+      form.save!
+      expect([ form.prompt_tokens, form.total_tokens ]).to eq([ 0, 0 ])
+    end
+
+    it 'storedの場合はキャッシュ済みのトークン数を加算すること' do
+      stub_const("EmbeddingForm::USAGE_MODE", "stored")
+      form.save!
+      expect([ form.prompt_tokens, form.total_tokens ]).to eq([ 16, 16 ])
+    end
+
+    it '新しいエントリにトークン数を保存すること' do
+      form.save!
+      expect(VectorCache.where(input_hash: fresh_texts.map { |text| Digest::SHA1.hexdigest(text) }).sum(:prompt_tokens)).to eq(8)
+    end
+  end
+
   describe '#save!' do
     before do
       stub_request(:post, "https://api.openai.com/v1/embeddings")
@@ -140,5 +300,133 @@ RSpec.describe EmbeddingForm do
       form.save!
       expect(EmbeddingRequest.first.input_length).to eq(21)
     end
+
+    it 'NO_TOUCHが有効でもキャッシュミスはEmbeddingRequestを記録すること' do
+      stub_const("EmbeddingForm::NO_TOUCH", true)
+      form = EmbeddingForm.new(valid_attributes)
+      expect { form.save! }.to change(EmbeddingRequest, :count).by(1)
+    end
+
+    context 'STORE_INPUT_TEXTが有効な場合' do
+      before do
+        stub_const("VectorCache::STORE_INPUT_TEXT", true)
+      end
+
+      it '入力テキストを保存すること' do
+        EmbeddingForm.new(valid_attributes).save!
+        expect(VectorCache.last.input_text).to eq("テストテキスト")
+      end
+    end
+
+    it 'デフォルトでは入力テキストを保存しないこと' do
+      EmbeddingForm.new(valid_attributes).save!
+      expect(VectorCache.last.input_text).to be_nil
+    end
+
+    context 'キャッシュにヒットした場合' do
+      let!(:vector_cache) do
+        EmbeddingModel.create!(name: "text-embedding-ada-002", default_dimensions: 3)
+        VectorCache.create!(
+          input_hash: Digest::SHA1.hexdigest("テストテキスト"),
+          content: Base64.strict_decode64("AAAAPgAAgD4AAAA/"),
+          model: "text-embedding-ada-002",
+          dimensions: 3
+        )
+      end
+
+      it 'NO_TOUCHが有効な場合は書き込みせずにキャッシュを返すこと' do
+        stub_const("EmbeddingForm::NO_TOUCH", true)
+        result = ActiveRecord::Base.while_preventing_writes do
+          EmbeddingForm.new(valid_attributes).save!
+        end
+        expect(result.first).to include(embedding: [ 0.125, 0.25, 0.5 ])
+        expect(vector_cache.reload.access_count).to eq(0)
+        expect(EmbeddingRequest.count).to eq(0)
+      end
+
+      context 'リードレプリカが設定されている場合' do
+        before do
+          stub_const("ApplicationRecord::READ_REPLICA", true)
+        end
+
+        it 'レプリカに接続できなければプライマリから読み込むこと' do
+          allow(ApplicationRecord).to receive(:connected_to).and_raise(ActiveRecord::ConnectionNotEstablished)
+          result = EmbeddingForm.new(valid_attributes).save!
+          expect(result.first).to include(embedding: [ 0.125, 0.25, 0.5 ])
+          expect(a_request(:post, "https://api.openai.com/v1/embeddings")).not_to have_been_made
+        end
+
+        it 'レプリカの遅延で見つからない場合はエラーにせずミスとして扱うこと' do
+          # the replica has neither the vector nor the model's default dimensions yet
+          allow(ApplicationRecord).to receive(:connected_to).and_return([], nil)
+          result = nil
+          expect { result = EmbeddingForm.new(valid_attributes).save! }.not_to change(VectorCache, :count)
+          expect(result.first).to include(embedding: [ 0.125, 0.25, 0.5 ])
+          expect(a_request(:post, "https://api.openai.com/v1/embeddings")).to have_been_made.once
+        end
+      end
+
+      it 'access_countを加算し、created_atを変更しないこと' do
+        created_at = vector_cache.created_at
+        EmbeddingForm.new(valid_attributes).save!
+        vector_cache.reload
+        expect(vector_cache.access_count).to eq(1)
+        expect(vector_cache.created_at).to eq(created_at)
+      end
+
+      it 'last_accessed_atを更新すること' do
+        travel 1.day do
+          EmbeddingForm.new(valid_attributes).save!
+          expect(vector_cache.reload.last_accessed_at).to be_within(1.second).of(Time.current)
+        end
+      end
+    end
+
+    context 'CACHE_ONLY_MODELSに含まれないモデルの場合' do
+      before do
+        stub_const("EmbeddingForm::CACHE_ONLY_MODELS", [ "text-embedding-3-large" ])
+      end
+
+      it 'キャッシュを参照・保存せずに埋め込みベクトルを返すこと' do
+        expect(VectorCache).not_to receive(:where)
+        form = EmbeddingForm.new(valid_attributes)
+        result = nil
+        expect { result = form.save! }
+          .to not_change(VectorCache, :count)
+          .and not_change(EmbeddingRequest, :count)
+          .and not_change(EmbeddingModel, :count)
+        expect(result.first).to include(embedding: [ 0.125, 0.25, 0.5 ])
+      end
+    end
+
+    context 'CACHE_ONLY_MODELSに含まれるモデルの場合' do
+      before do
+        stub_const("EmbeddingForm::CACHE_ONLY_MODELS", [ "text-embedding-ada-002" ])
+      end
+
+      it 'キャッシュに保存すること' do
+        form = EmbeddingForm.new(valid_attributes)
+        expect { form.save! }.to change(VectorCache, :count).by(1)
+      end
+    end
+
+    context 'WARN_ON_LARGE_VECTORSが有効な場合' do
+      before do
+        stub_const("EmbeddingForm::WARN_ON_LARGE_VECTORS", true)
+        allow(Rails.logger).to receive(:warn)
+      end
+
+      it '想定より大きいベクトルの場合は警告を出すこと' do
+        EmbeddingModel.create!(name: "text-embedding-ada-002", default_dimensions: 2)
+        EmbeddingForm.new(valid_attributes).save!
+        expect(Rails.logger).to have_received(:warn).with(/12 bytes, expected at most 8 bytes for text-embedding-ada-002 with 2 dimensions/)
+      end
+
+      it '想定どおりの大きさの場合は警告を出さないこと' do
+        EmbeddingModel.create!(name: "text-embedding-ada-002", default_dimensions: 3)
+        EmbeddingForm.new(valid_attributes).save!
+        expect(Rails.logger).not_to have_received(:warn)
+      end
+    end
   end
 end
@@ -54,7 +54,79 @@ RSpec.describe EmbeddingTarget do
       it 'エラーを発生させること' do
         expect {
           described_class.build_targets!({ invalid: 'format' })
-        }.to raise_error(RuntimeError, /Invalid input format/)
+        }.to raise_error(EmbeddingTarget::InvalidInputError, /Invalid input format/)
+      end
+    end
+
+    context 'inputがnilの場合' do
+      it 'エラーを発生させること' do
+        expect {
+          described_class.build_targets!(nil)
+        }.to raise_error(EmbeddingTarget::InvalidInputError, "input is required")
+      end
+    end
+
+    context '空配列が入力された場合' do
+      it 'エラーを発生させること' do
+        expect {
+          described_class.build_targets!([])
+        }.to raise_error(EmbeddingTarget::InvalidInputError, "input must not be empty")
+      end
+    end
+
+    context '無効な入力が長い場合' do
+      it 'エラーメッセージには先頭だけを含めること' do
+        expect {
+          described_class.build_targets!([ 1.5 ] * 10_000)
+        }.to raise_error(EmbeddingTarget::InvalidInputError) { |error| expect(error.message.size).to be < 300 }
+      end
+    end
+
+    context 'ランダムな形の入力の場合' do
+      def random_input(random, depth = 0)
+        case random.rand(depth > 3 ? 5 : 7)
+        when 0 then random.rand(-2**40..2**40)
+        when 1 then [ '', 'hi', '1', 'テスト' ].sample(random: random)
+        when 2 then random.rand
+        when 3 then nil
+        when 4 then [ true, false ].sample(random: random)
+        when 5 then Array.new(random.rand(4)) { random_input(random, depth + 1) }
+        when 6 then { 'text' => random_input(random, depth + 1) }
+        end
+      end
+
+      it 'EmbeddingTargetの配列を返すかInvalidInputErrorを発生させること' do
+        random = Random.new(RSpec.configuration.seed)
+        inputs = [ 'テスト', [ 1, 2 ], [ 'a', 'b' ], [ [ 1 ], [ 2 ] ], { 'text' => 'hi' }, [ '1', 2 ] ]
+        (inputs + Array.new(1000) { random_input(random) }).each do |input|
+          targets = described_class.build_targets!(input)
+          expect(targets).to all(be_an(EmbeddingTarget))
+          targets.each(&:sha1sum)
+        rescue EmbeddingTarget::InvalidInputError
+          next
+        end
+      end
+    end
+
+    context '空文字列が入力された場合' do
+      it 'エラーを発生させること' do
+        expect {
+          described_class.build_targets!('')
+        }.to raise_error(EmbeddingTarget::InvalidInputError, "input must not be an empty string")
+      end
+    end
+
+    context '配列の要素が空の場合' do
+      it '空文字列の位置を含むエラーを発生させること' do
+        expect {
+          described_class.build_targets!([ 'テスト1', '', 'テスト3' ])
+        }.to raise_error(EmbeddingTarget::InvalidInputError, "input at index 1 must not be empty")
+      end
+
+      it '空のトークン配列の位置を含むエラーを発生させること' do
+        expect {
+          described_class.build_targets!([ [ 1, 2 ], [ 3 ], [] ])
+        }.to raise_error(EmbeddingTarget::InvalidInputError, "input at index 2 must not be empty")
       end
     end
   end
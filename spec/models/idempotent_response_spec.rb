@@ -0,0 +1,40 @@
+require 'rails_helper'
+
+RSpec.describe IdempotentResponse do
+  def store(body)
+    described_class.store!(
+      key_digest: described_class.digest("sk-abc123", "", "key-1"),
+      request_digest: described_class.digest("{}"),
+      status: 200,
+      body: body,
+      headers: { "X-Cachembed-Cache-Hits" => "0", "Content-Type" => "application/json" },
+      expires_at: 1.hour.from_now
+    )
+  end
+
+  describe '.store!' do
+    # CI runs this on MySQL too, where a plain TEXT column holds only 64 KB
+    it '大きなレスポンスを切り詰めずに保存すること' do
+      data = Array.new(20) { |index| { object: "embedding", index: index, embedding: Array.new(1536) { |i| (i * 0.000123456789) - 0.1 } } }
+      body = { object: "list", data: data, model: "text-embedding-3-small" }.to_json
+      expect(body.bytesize).to be > 64.kilobytes
+
+      store(body)
+
+      expect(described_class.sole.body).to eq(body)
+    end
+
+    it 'X-Cachembed-で始まるヘッダーだけを保存すること' do
+      store("{}")
+
+      expect(described_class.sole.headers).to eq({ "X-Cachembed-Cache-Hits" => "0" })
+    end
+
+    it '列の上限を超えるレスポンスは保存せずにエラーとなること' do
+      stub_const("IdempotentResponse::MAX_BODY_BYTES", 10)
+
+      expect { store("x" * 11) }.to raise_error(ActiveRecord::RecordInvalid, /11 bytes, larger than 10/)
+      expect(described_class.count).to eq(0)
+    end
+  end
+end
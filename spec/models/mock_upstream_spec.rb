@@ -0,0 +1,55 @@
+require 'rails_helper'
+
+RSpec.describe MockUpstream do
+  let(:request_body) { { model: "text-embedding-3-small", input: [ "Hello, world!", [ 1, 2, 3 ] ], encoding_format: "base64" } }
+
+  describe '.url?' do
+    it 'mockスキームのみ受け付けること' do
+      expect(MockUpstream.url?("mock://deterministic?dim=3")).to be true
+      expect(MockUpstream.url?("https://api.openai.com/v1/embeddings")).to be false
+    end
+  end
+
+  describe '#embed' do
+    it 'dimの次元数で返すこと' do
+      body = MockUpstream.new("mock://deterministic?dim=3").embed(request_body)
+      expect(body[:data].map { |item| Base64.strict_decode64(item[:embedding]).unpack("f*").size }).to eq([ 3, 3 ])
+      expect(body[:data].map { |item| item[:index] }).to eq([ 0, 1 ])
+    end
+
+    it 'リクエストのdimensionsを優先すること' do
+      body = MockUpstream.new("mock://deterministic?dim=3").embed(request_body.merge(dimensions: 8))
+      expect(Base64.strict_decode64(body[:data].first[:embedding]).unpack("f*").size).to eq(8)
+    end
+
+    it 'encoding_formatがfloatなら同じ埋め込みを配列で返すこと' do
+      base64 = MockUpstream.new("mock://deterministic?dim=3").embed(request_body)
+      float = MockUpstream.new("mock://deterministic?dim=3").embed(request_body.merge(encoding_format: "float"))
+      expect(float[:data].first[:embedding]).to eq(Base64.strict_decode64(base64[:data].first[:embedding]).unpack("e*"))
+    end
+
+    it 'プロセスをまたいでも同じ埋め込みを返すこと' do
+      body = MockUpstream.new("mock://deterministic?dim=3").embed(request_body)
+      expect(body[:data].first[:embedding]).to eq("E2pPP92lFD/YxaO9")
+      expect(MockUpstream.new("mock://deterministic?dim=3").embed(request_body)).to eq(body)
+    end
+
+    it 'seedが異なれば異なる埋め込みを返すこと' do
+      body = MockUpstream.new("mock://deterministic?dim=3").embed(request_body)
+      seeded = MockUpstream.new("mock://deterministic?dim=3&seed=ci").embed(request_body)
+      expect(seeded[:data].first[:embedding]).not_to eq(body[:data].first[:embedding])
+      expect(MockUpstream.new("mock://deterministic?dim=3&seed=ci").embed(request_body)).to eq(seeded)
+    end
+
+    it '入力が異なれば異なる埋め込みを返すこと' do
+      body = MockUpstream.new("mock://deterministic?dim=3").embed(request_body)
+      expect(body[:data][0][:embedding]).not_to eq(body[:data][1][:embedding])
+    end
+  end
+
+  describe '#initialize' do
+    it 'deterministic以外はエラーになること' do
+      expect { MockUpstream.new("mock://random") }.to raise_error(ArgumentError)
+    end
+  end
+end
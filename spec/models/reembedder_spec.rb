@@ -0,0 +1,51 @@
+require 'rails_helper'
+
+RSpec.describe Reembedder do
+  let(:text) { "Hello, world!" }
+
+  before do
+    VectorCache.create!(
+      input_hash: Digest::SHA1.hexdigest(text),
+      content: Base64.strict_decode64("AAAAPgAAgD4AAAA/"),
+      model: "text-embedding-ada-002",
+      dimensions: 3,
+      input_text: text
+    )
+    VectorCache.create!(
+      input_hash: Digest::SHA1.hexdigest("without text"),
+      content: Base64.strict_decode64("AAAAPgAAgD4AAAA/"),
+      model: "text-embedding-ada-002",
+      dimensions: 3
+    )
+    stub_request(:post, UpstreamClient::URL)
+      .with(body: { model: "text-embedding-3-small", input: [ text ], encoding_format: "base64" })
+      .to_return(
+        status: 200,
+        headers: { 'Content-Type' => 'application/json' },
+        body: {
+          data: [ { object: "embedding", embedding: "AADAPgAAQD8AAGA/", index: 0 } ],
+          model: "text-embedding-3-small",
+          usage: { prompt_tokens: 4, total_tokens: 4 }
+        }.to_json
+      )
+  end
+
+  subject(:reembedder) do
+    described_class.new(from_model: "text-embedding-ada-002", to_model: "text-embedding-3-small", api_key: "sk-abc123")
+  end
+
+  describe '#run' do
+    it '入力テキストが保存されたエントリのみを別のモデルで埋め込み直すこと' do
+      expect(reembedder.run).to eq(1)
+      vector = VectorCache.find_by(model: "text-embedding-3-small")
+      expect(vector.input_hash).to eq(Digest::SHA1.hexdigest(text))
+      expect(vector.float_array_content).to eq([ 0.375, 0.75, 0.875 ])
+    end
+
+    it '既に埋め込み済みのエントリはスキップすること' do
+      reembedder.run
+      expect(reembedder.run).to eq(0)
+      expect(a_request(:post, UpstreamClient::URL)).to have_been_made.once
+    end
+  end
+end
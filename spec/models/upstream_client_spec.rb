@@ -97,5 +97,42 @@ RSpec.describe UpstreamClient do
         expect { client.post }.to raise_error(/Failed to get embedding from upstream/)
       end
     end
+
+    context 'OpenAI形式のエラーレスポンスの場合' do
+      before do
+        stub_request(:post, UpstreamClient::URL)
+          .to_return(
+            status: 429,
+            body: {
+              error: {
+                message: "Rate limit reached",
+                type: "requests",
+                param: nil,
+                code: "rate_limit_exceeded"
+              }
+            }.to_json,
+            headers: { 'Content-Type' => 'application/json' }
+          )
+      end
+
+      it 'ステータスとエラー内容を保持したUpstreamErrorを発生させること' do
+        expect { client.post }.to raise_error(UpstreamClient::UpstreamError) { |e|
+          expect(e.status).to eq(429)
+          expect(e.to_hash).to eq(
+            message: "Rate limit reached",
+            type: "requests",
+            param: nil,
+            code: "rate_limit_exceeded"
+          )
+        }
+      end
+    end
+  end
+
+  describe '.connection' do
+    it 'プロセス内で同じ持続的接続を使い回すこと' do
+      expect(described_class.connection).to be(described_class.connection)
+      expect(described_class.connection.builder.adapter.klass).to eq(Faraday::Adapter::NetHttpPersistent)
+    end
   end
 end
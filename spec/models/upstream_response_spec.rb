@@ -72,6 +72,135 @@ RSpec.describe UpstreamResponse do
         expect(result[0][:input_hash]).to eq(target.sha1sum)
         expect(result[1][:input_hash]).to eq(target2.sha1sum)
       end
+
+      it 'splits prompt_tokens across targets by input length' do
+        result = response.vector_cache_hashes
+        # 'Hello, world!' is 13 bytes and 'Another text' is 12 bytes
+        expect(result.map { |hash| hash[:prompt_tokens] }).to eq([ 8, 8 ])
+        expect(result.sum { |hash| hash[:prompt_tokens] }).to eq(16)
+      end
+    end
+
+    context 'when upstream returns float arrays' do
+      let(:body) do
+        {
+          object: 'list',
+          data: [ { object: 'embedding', embedding: [ 0.125, 0.25, 0.5 ], index: 0 } ],
+          model: model,
+          usage: { prompt_tokens: 8, total_tokens: 8 }
+        }
+      end
+
+      it 'stores them as packed float32' do
+        hash = response.vector_cache_hashes.first
+        expect(hash[:content]).to eq(Base64.strict_decode64('AAAAPgAAgD4AAAA/'))
+        expect(hash[:dimensions]).to eq(3)
+      end
+    end
+
+    context 'when upstream returns corrupt base64' do
+      let(:body) do
+        {
+          object: 'list',
+          data: [ { object: 'embedding', embedding: 'not base64!', index: 0 } ],
+          model: model,
+          usage: { prompt_tokens: 8, total_tokens: 8 }
+        }
+      end
+
+      it 'raises InvalidResponseError' do
+        expect { response.vector_cache_hashes }
+          .to raise_error(UpstreamResponse::InvalidResponseError, "Upstream returned an embedding that is not valid base64 at index 0")
+      end
+    end
+
+    context 'when upstream returns base64 that is not float32 aligned' do
+      let(:body) do
+        {
+          object: 'list',
+          data: [ { object: 'embedding', embedding: 'AAAA', index: 0 } ],
+          model: model,
+          usage: { prompt_tokens: 8, total_tokens: 8 }
+        }
+      end
+
+      it 'raises InvalidResponseError' do
+        expect { response.vector_cache_hashes }
+          .to raise_error(UpstreamResponse::InvalidResponseError, /3 bytes of embedding at index 0/)
+      end
+    end
+
+    context 'when upstream returns fewer embeddings than targets' do
+      let(:target2) { EmbeddingTarget.new('Another text') }
+
+      subject(:response) { described_class.new(body: body, targets: [ target, target2 ], model: model) }
+
+      it 'raises InvalidResponseError' do
+        expect { response.vector_cache_hashes }
+          .to raise_error(UpstreamResponse::InvalidResponseError, "Upstream returned 1 embeddings for 2 inputs")
+      end
+    end
+
+    context 'when upstream returns embeddings out of index order' do
+      let(:target2) { EmbeddingTarget.new('Another text') }
+      let(:body) do
+        {
+          object: 'list',
+          data: [
+            { object: 'embedding', embedding: 'AACAPgAAAD8AAAA+', index: 1 },
+            { object: 'embedding', embedding: 'AAAAPgAAgD4AAAA/', index: 0 }
+          ],
+          model: model,
+          usage: { prompt_tokens: 8, total_tokens: 8 }
+        }
+      end
+
+      subject(:response) { described_class.new(body: body, targets: [ target, target2 ], model: model) }
+
+      it 'orders the embeddings by index' do
+        expect(response.vector_cache_hashes.map { |hash| [ hash[:input_hash], Base64.strict_encode64(hash[:content]) ] }).to eq([
+          [ target.sha1sum, 'AAAAPgAAgD4AAAA/' ],
+          [ target2.sha1sum, 'AACAPgAAAD8AAAA+' ]
+        ])
+      end
+    end
+
+    context 'when upstream returns a duplicate index' do
+      let(:target2) { EmbeddingTarget.new('Another text') }
+      let(:body) do
+        {
+          object: 'list',
+          data: [
+            { object: 'embedding', embedding: 'AAAAPgAAgD4AAAA/', index: 0 },
+            { object: 'embedding', embedding: 'AACAPgAAAD8AAAA+', index: 0 }
+          ],
+          model: model,
+          usage: { prompt_tokens: 8, total_tokens: 8 }
+        }
+      end
+
+      subject(:response) { described_class.new(body: body, targets: [ target, target2 ], model: model) }
+
+      it 'raises InvalidResponseError' do
+        expect { response.vector_cache_hashes }
+          .to raise_error(UpstreamResponse::InvalidResponseError, "Upstream returned duplicate index 0 and no index 1")
+      end
+    end
+
+    context 'when upstream returns an index outside the inputs' do
+      let(:body) do
+        {
+          object: 'list',
+          data: [ { object: 'embedding', embedding: 'AAAAPgAAgD4AAAA/', index: 1 } ],
+          model: model,
+          usage: { prompt_tokens: 8, total_tokens: 8 }
+        }
+      end
+
+      it 'raises InvalidResponseError' do
+        expect { response.vector_cache_hashes }
+          .to raise_error(UpstreamResponse::InvalidResponseError, "Upstream returned index 1 for 1 inputs")
+      end
     end
   end
 end
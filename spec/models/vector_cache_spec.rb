@@ -1,5 +1,83 @@
 require 'rails_helper'
 
 RSpec.describe VectorCache, type: :model do
-  pending "add some examples to (or delete) #{__FILE__}"
+  describe 'バリデーション' do
+    let(:input_hash) { Digest::SHA1.hexdigest("Hello, world!") }
+
+    before do
+      VectorCache.create!(input_hash: input_hash, content: "AAAA", model: "text-embedding-3-small", dimensions: 256)
+    end
+
+    it '同じinput_hashでもdimensionsが異なれば保存できること' do
+      vector = VectorCache.new(input_hash: input_hash, content: "AAAA", model: "text-embedding-3-small", dimensions: 1536)
+      expect(vector).to be_valid
+    end
+
+    it '同じinput_hashでもmodelが異なれば保存できること' do
+      vector = VectorCache.new(input_hash: input_hash, content: "AAAA", model: "text-embedding-3-large", dimensions: 256)
+      expect(vector).to be_valid
+    end
+
+    it 'input_hash、model、dimensionsが同じ場合は無効であること' do
+      vector = VectorCache.new(input_hash: input_hash, content: "AAAA", model: "text-embedding-3-small", dimensions: 256)
+      vector.valid?
+      expect(vector.errors[:input_hash]).to include("has already been taken")
+    end
+
+    it '既存のエントリを上書きしないこと' do
+      VectorCache.create!(input_hash: input_hash, content: "BBBB", model: "text-embedding-3-small", dimensions: 1536)
+      expect(VectorCache.find_by(input_hash: input_hash, dimensions: 256).content).to eq("AAAA")
+      expect(VectorCache.find_by(input_hash: input_hash, dimensions: 1536).content).to eq("BBBB")
+    end
+  end
+
+  describe '#base64_content' do
+    it '量子化されたエントリはfloat32に戻してエンコードすること' do
+      floats = [ 0.125, 0.25, 0.5 ]
+      vector = VectorCache.new(
+        content: VectorQuantizer.quantize(floats.pack("f*"), "float16"),
+        quantization: "float16"
+      )
+      expect(Base64.strict_decode64(vector.base64_content).unpack("f*")).to eq(floats)
+      expect(vector.float_array_content).to eq(floats)
+    end
+  end
+
+  describe '.expired' do
+    it 'expires_atを過ぎたエントリのみ返すこと' do
+      freeze_time do
+        expired = VectorCache.create!(input_hash: "a" * 40, content: "AAAA", model: "text-embedding-ada-002", dimensions: 1, expires_at: 1.second.ago)
+        live = VectorCache.create!(input_hash: "b" * 40, content: "AAAA", model: "text-embedding-ada-002", dimensions: 1, expires_at: 1.hour.from_now)
+        forever = VectorCache.create!(input_hash: "c" * 40, content: "AAAA", model: "text-embedding-ada-002", dimensions: 1)
+        expect(VectorCache.expired).to eq([ expired ])
+        expect(VectorCache.unexpired).to contain_exactly(live, forever)
+      end
+    end
+  end
+
+  describe '.most_accessed' do
+    it 'access_countの降順で返すこと' do
+      cold = VectorCache.create!(input_hash: "a" * 40, content: "AAAA", model: "text-embedding-ada-002", dimensions: 1, access_count: 1)
+      hot = VectorCache.create!(input_hash: "b" * 40, content: "AAAA", model: "text-embedding-ada-002", dimensions: 1, access_count: 5)
+      expect(VectorCache.most_accessed).to eq([ hot, cold ])
+    end
+  end
+
+  describe 'last_accessed_at' do
+    let(:hash) { { input_hash: "a" * 40, content: "AAAA", model: "text-embedding-ada-002", dimensions: 1, key_version: "", tenant: "" } }
+
+    it '保存時に設定されること' do
+      freeze_time do
+        expect(VectorCache.create!(hash).last_accessed_at).to eq(Time.current)
+      end
+    end
+
+    it '期限切れのエントリを置き換えた場合は更新されること' do
+      vector = VectorCache.create!(hash.merge(expires_at: 1.minute.from_now))
+      travel 1.hour do
+        VectorCache.import_hashes!([ hash.merge(content: "BBBB") ])
+        expect(vector.reload.last_accessed_at).to be_within(1.second).of(Time.current)
+      end
+    end
+  end
 end
@@ -0,0 +1,25 @@
+require 'rails_helper'
+
+RSpec.describe VectorCacheWriter, type: :model do
+  let(:vector_cache_hash) do
+    { input_hash: "a" * 40, content: "AAAA", model: "text-embedding-3-small", dimensions: 1 }
+  end
+
+  after { VectorCacheWriter.flush }
+
+  describe '.enqueue' do
+    it 'flush後に保存されていること' do
+      VectorCacheWriter.enqueue([ vector_cache_hash ])
+      VectorCacheWriter.flush
+      expect(VectorCache.find_by(input_hash: "a" * 40)).to be_present
+    end
+
+    it '保存に失敗した件数を数えること' do
+      allow(VectorCache).to receive(:import_hashes!).and_raise(ActiveRecord::StatementInvalid)
+      expect {
+        VectorCacheWriter.enqueue([ vector_cache_hash ])
+        VectorCacheWriter.flush
+      }.to change(VectorCacheWriter, :failed_writes).by(1)
+    end
+  end
+end
@@ -0,0 +1,34 @@
+require 'rails_helper'
+
+RSpec.describe VectorEncoding do
+  describe '.pack' do
+    it 'リトルエンディアンのfloat32で詰めること' do
+      expect(VectorEncoding.pack([ 1.0 ]).bytes).to eq([ 0x00, 0x00, 0x80, 0x3f ])
+    end
+
+    it '元の値に戻せること' do
+      floats = [ 0.125, -0.25, 0.5, 3.0e-5 ]
+      expect(VectorEncoding.unpack(VectorEncoding.pack(floats))).to eq(floats.pack("e*").unpack("e*"))
+    end
+
+    it '空のベクトルを扱えること' do
+      expect(VectorEncoding.unpack(VectorEncoding.pack([]))).to eq([])
+      expect(VectorEncoding.encode_base64(VectorEncoding.pack([]))).to eq("")
+    end
+  end
+
+  describe '.decode_base64' do
+    it 'OpenAIのbase64埋め込みを読めること' do
+      expect(VectorEncoding.unpack(VectorEncoding.decode_base64("AAAAPgAAgD4AAAA/"))).to eq([ 0.125, 0.25, 0.5 ])
+    end
+
+    it 'エンコードと往復できること' do
+      binary = VectorEncoding.pack([ 0.125, 0.25, 0.5 ])
+      expect(VectorEncoding.decode_base64(VectorEncoding.encode_base64(binary))).to eq(binary)
+    end
+
+    it '不正なbase64はArgumentErrorになること' do
+      expect { VectorEncoding.decode_base64("AAAAPgA") }.to raise_error(ArgumentError)
+    end
+  end
+end
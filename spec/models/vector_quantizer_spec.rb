@@ -0,0 +1,40 @@
+require 'rails_helper'
+
+RSpec.describe VectorQuantizer do
+  let(:floats) { [ 0.125, -0.5, 0.0123, -0.9876, 0.0 ] }
+  let(:binary) { floats.pack("f*") }
+
+  describe '.quantize / .dequantize' do
+    it 'noneの場合はそのまま保存すること' do
+      quantized = described_class.quantize(binary, "none")
+      expect(quantized).to eq(binary)
+      expect(described_class.dequantize(quantized, "none")).to eq(binary.unpack("f*"))
+    end
+
+    it 'float16の場合は半分のサイズで近似値に戻せること' do
+      quantized = described_class.quantize(binary, "float16")
+      expect(quantized.bytesize).to eq(floats.size * 2)
+      described_class.dequantize(quantized, "float16").zip(floats).each do |actual, expected|
+        expect(actual).to be_within(expected.abs * 1e-3 + 1e-7).of(expected)
+      end
+    end
+
+    it 'int8の場合はスケールと1バイトずつで近似値に戻せること' do
+      quantized = described_class.quantize(binary, "int8")
+      expect(quantized.bytesize).to eq(4 + floats.size)
+      tolerance = floats.map(&:abs).max / 127 / 2 + 1e-6
+      described_class.dequantize(quantized, "int8").zip(floats).each do |actual, expected|
+        expect(actual).to be_within(tolerance).of(expected)
+      end
+    end
+
+    it 'int8ですべて0の場合は0に戻せること' do
+      quantized = described_class.quantize([ 0.0, 0.0 ].pack("f*"), "int8")
+      expect(described_class.dequantize(quantized, "int8")).to eq([ 0.0, 0.0 ])
+    end
+
+    it '未知の方式の場合はエラーを発生させること' do
+      expect { described_class.quantize(binary, "int4") }.to raise_error(ArgumentError)
+    end
+  end
+end
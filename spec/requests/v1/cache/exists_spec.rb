@@ -0,0 +1,51 @@
+require 'rails_helper'
+
+RSpec.describe "V1::Cache::Exists", type: :request do
+  let(:headers) do
+    {
+      "Authorization" => "Bearer sk-abc123",
+      "Content-Type" => "application/json",
+      "Accept" => "application/json"
+    }
+  end
+
+  before do
+    VectorCache.create!(input_hash: Digest::SHA1.hexdigest("Hello, world!"), content: "AAAA", model: "text-embedding-3-small", dimensions: 256)
+  end
+
+  describe "POST /v1/cache/exists" do
+    it "returns whether each input is cached" do
+      post v1_cache_exists_path, headers: headers, params: {
+        inputs: [ "Hello, world!", "Goodbye, world!" ],
+        model: "text-embedding-3-small",
+        dimensions: 256
+      }.to_json
+
+      expect(response).to be_successful
+      expect(JSON.parse(response.body)["cached"]).to eq([ true, false ])
+      expect(a_request(:post, "https://api.openai.com/v1/embeddings")).not_to have_been_made
+    end
+
+    it "uses the model's default dimensions when dimensions is omitted" do
+      EmbeddingModel.create!(name: "text-embedding-3-small", default_dimensions: 256)
+      post v1_cache_exists_path, headers: headers, params: {
+        inputs: [ "Hello, world!" ],
+        model: "text-embedding-3-small"
+      }.to_json
+
+      expect(JSON.parse(response.body)["cached"]).to eq([ true ])
+    end
+
+    it "returns 400 for an invalid input" do
+      post v1_cache_exists_path, headers: headers, params: { inputs: [], model: "text-embedding-3-small" }.to_json
+
+      expect(response).to have_http_status(:bad_request)
+    end
+
+    it "returns 422 for an unknown model" do
+      post v1_cache_exists_path, headers: headers, params: { inputs: [ "Hello, world!" ], model: "unknown" }.to_json
+
+      expect(response).to have_http_status(:unprocessable_entity)
+    end
+  end
+end
@@ -0,0 +1,55 @@
+require 'rails_helper'
+
+RSpec.describe "V1::Cache::Models", type: :request do
+  before do
+    VectorCache.create!(input_hash: "a" * 40, content: "AAAABBBB", model: "text-embedding-3-small", dimensions: 2)
+    VectorCache.create!(input_hash: "b" * 40, content: "AAAABBBB", model: "text-embedding-3-small", dimensions: 2)
+    VectorCache.create!(input_hash: "a" * 40, content: "AAAA", model: "text-embedding-3-small", dimensions: 1)
+    VectorCache.create!(input_hash: "a" * 40, content: "AAAA", model: "text-embedding-ada-002", dimensions: 1)
+  end
+
+  describe "GET /v1/cache/models" do
+    context "when an admin token is configured" do
+      before { stub_const("AdminTokenAuthentication::ADMIN_TOKEN", "admin-secret") }
+
+      it "returns entries and bytes per model and dimensions" do
+        get v1_cache_models_path, headers: { "Authorization" => "Bearer admin-secret" }
+
+        expect(response).to be_successful
+        expect(JSON.parse(response.body)["data"]).to eq([
+          {
+            "model" => "text-embedding-3-small",
+            "entries" => 3,
+            "bytes" => 20,
+            "dimensions" => [
+              { "dimensions" => 1, "entries" => 1, "bytes" => 4 },
+              { "dimensions" => 2, "entries" => 2, "bytes" => 16 }
+            ]
+          },
+          {
+            "model" => "text-embedding-ada-002",
+            "entries" => 1,
+            "bytes" => 4,
+            "dimensions" => [
+              { "dimensions" => 1, "entries" => 1, "bytes" => 4 }
+            ]
+          }
+        ])
+      end
+
+      it "returns 401 for a wrong token" do
+        get v1_cache_models_path, headers: { "Authorization" => "Bearer sk-abc123" }
+
+        expect(response).to have_http_status(:unauthorized)
+      end
+    end
+
+    context "when no admin token is configured" do
+      it "returns 404" do
+        get v1_cache_models_path, headers: { "Authorization" => "Bearer sk-abc123" }
+
+        expect(response).to have_http_status(:not_found)
+      end
+    end
+  end
+end
@@ -0,0 +1,112 @@
+require 'rails_helper'
+
+RSpec.describe "V1::Cache::Searches", type: :request do
+  let(:headers) do
+    {
+      "Authorization" => "Bearer sk-abc123",
+      "Content-Type" => "application/json",
+      "Accept" => "application/json"
+    }
+  end
+
+  before do
+    {
+      "a" => [ 1.0, 0.0, 0.0 ],
+      "b" => [ 0.0, 1.0, 0.0 ],
+      "c" => [ 0.9, 0.1, 0.0 ]
+    }.each do |char, vector|
+      VectorCache.create!(input_hash: char * 40, content: vector.pack("f*"), model: "text-embedding-3-small", dimensions: 3)
+    end
+  end
+
+  describe "POST /v1/cache/search" do
+    context "when search is enabled" do
+      before { stub_const("VectorSearch::ENABLED", true) }
+
+      it "returns the nearest cached hashes ordered by similarity" do
+        post v1_cache_search_path, headers: headers, params: {
+          model: "text-embedding-3-small",
+          embedding: [ 1.0, 0.0, 0.0 ],
+          top_k: 2
+        }.to_json
+
+        expect(response).to be_successful
+        body = JSON.parse(response.body)
+        expect(body["data"].map { |result| result["input_hash"] }).to eq([ "a" * 40, "c" * 40 ])
+        expect(body["data"].first["similarity"]).to be_within(1e-6).of(1.0)
+      end
+
+      it "keeps the nearest hashes across scan batches" do
+        stub_const("VectorSearch::SCAN_BATCH_SIZE", 1)
+        post v1_cache_search_path, headers: headers, params: {
+          model: "text-embedding-3-small",
+          embedding: [ 1.0, 0.0, 0.0 ],
+          top_k: 2
+        }.to_json
+
+        expect(response).to be_successful
+        expect(JSON.parse(response.body)["data"].map { |result| result["input_hash"] }).to eq([ "a" * 40, "c" * 40 ])
+      end
+
+      it "scores quantized entries by their dequantized vectors" do
+        {
+          "d" => [ "float16", [ 0.0, 0.0, 1.0 ] ],
+          "e" => [ "int8", [ 0.1, 0.0, 0.9 ] ]
+        }.each do |char, (quantization, vector)|
+          VectorCache.create!(input_hash: char * 40, content: VectorQuantizer.quantize(vector.pack("e*"), quantization), quantization: quantization, model: "text-embedding-3-small", dimensions: 3)
+        end
+
+        post v1_cache_search_path, headers: headers, params: {
+          model: "text-embedding-3-small",
+          embedding: [ 0.0, 0.0, 1.0 ],
+          top_k: 2
+        }.to_json
+
+        expect(response).to be_successful
+        body = JSON.parse(response.body)
+        expect(body["data"].map { |result| result["input_hash"] }).to eq([ "d" * 40, "e" * 40 ])
+        expect(body["data"].first["similarity"]).to be_within(1e-3).of(1.0)
+      end
+
+      it "returns 422 for a non-numeric embedding" do
+        post v1_cache_search_path, headers: headers, params: {
+          model: "text-embedding-3-small",
+          embedding: [ "x" ]
+        }.to_json
+
+        expect(response).to have_http_status(:unprocessable_entity)
+        expect(JSON.parse(response.body)["errors"]).to include("Embedding must be a non-empty array of numbers")
+      end
+
+      it "returns 422 when too many vectors would be scanned" do
+        stub_const("VectorSearch::MAX_ROWS", 2)
+        post v1_cache_search_path, headers: headers, params: {
+          model: "text-embedding-3-small",
+          embedding: [ 1.0, 0.0, 0.0 ]
+        }.to_json
+
+        expect(response).to have_http_status(:unprocessable_entity)
+      end
+
+      it "returns 401 without an API key" do
+        post v1_cache_search_path, headers: headers.except("Authorization"), params: {
+          model: "text-embedding-3-small",
+          embedding: [ 1.0, 0.0, 0.0 ]
+        }.to_json
+
+        expect(response).to have_http_status(:unauthorized)
+      end
+    end
+
+    context "when search is disabled" do
+      it "returns 404" do
+        post v1_cache_search_path, headers: headers, params: {
+          model: "text-embedding-3-small",
+          embedding: [ 1.0, 0.0, 0.0 ]
+        }.to_json
+
+        expect(response).to have_http_status(:not_found)
+      end
+    end
+  end
+end
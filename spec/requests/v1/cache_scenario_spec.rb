@@ -0,0 +1,72 @@
+require 'rails_helper'
+
+# One cache lifecycle from miss to purge, checking the stored rows between steps.
+# CI runs it against SQLite, PostgreSQL and MySQL through DATABASE_URL.
+RSpec.describe "Cache scenario", type: :request do
+  let(:upstream_url) { "mock://deterministic?dim=4&seed=scenario" }
+  let(:upstream) { MockUpstream.new(upstream_url) }
+
+  before do
+    stub_const("UpstreamClient::URL", upstream_url)
+    stub_const("VectorCache::STORE_INPUT_TEXT", true)
+    allow(MockUpstream).to receive(:new).and_return(upstream)
+    allow(upstream).to receive(:embed).and_call_original
+  end
+
+  def post_embedding(input, encoding_format: "float", headers: {})
+    post v1_embeddings_path, headers: {
+      "Authorization" => "Bearer sk-abc123",
+      "Content-Type" => "application/json",
+      "Accept" => "application/json"
+    }.merge(headers), params: {
+      model: "text-embedding-3-small",
+      input: input,
+      encoding_format: encoding_format
+    }.to_json
+    expect(response).to be_successful
+    JSON.parse(response.body)["data"].map { |item| item["embedding"] }
+  end
+
+  def cached_inputs
+    VectorCache.order(:input_text).pluck(:input_text)
+  end
+
+  it "misses, hits, partially hits, serves base64, expires and purges" do
+    # miss
+    alpha = post_embedding("alpha").first
+    expect(upstream).to have_received(:embed).with(hash_including(input: [ "alpha" ])).once
+    expect(cached_inputs).to eq([ "alpha" ])
+    expect(VectorCache.find_by(input_text: "alpha").dimensions).to eq(4)
+
+    # hit
+    expect(post_embedding("alpha")).to eq([ alpha ])
+    expect(upstream).to have_received(:embed).once
+    expect(VectorCache.find_by(input_text: "alpha").access_count).to eq(1)
+
+    # partial hit: only the new input goes upstream
+    alpha_and_beta = post_embedding([ "alpha", "beta" ])
+    expect(alpha_and_beta.first).to eq(alpha)
+    expect(upstream).to have_received(:embed).with(hash_including(input: [ "beta" ])).once
+    expect(cached_inputs).to eq([ "alpha", "beta" ])
+
+    # base64 from the same rows
+    base64s = post_embedding([ "alpha", "beta" ], encoding_format: "base64")
+    expect(base64s.map { |base64| VectorEncoding.unpack(VectorEncoding.decode_base64(base64)) }).to eq(alpha_and_beta)
+    expect(upstream).to have_received(:embed).twice
+
+    # expiry, as run by cachembed:expire
+    post_embedding("gamma", headers: { "X-Cachembed-Expires-In" => "60" })
+    expect(cached_inputs).to eq([ "alpha", "beta", "gamma" ])
+    travel 2.minutes do
+      expect(VectorCache.expired.delete_all).to eq(1)
+    end
+    expect(cached_inputs).to eq([ "alpha", "beta" ])
+
+    # purge, as run by cachembed:forget
+    result = CacheEraser.new.erase("alpha")
+    expect(result.deleted).to eq(1)
+    expect(cached_inputs).to eq([ "beta" ])
+    expect(post_embedding("alpha")).to eq([ alpha ])
+    expect(upstream).to have_received(:embed).with(hash_including(input: [ "alpha" ])).twice
+  end
+end
@@ -175,6 +175,763 @@ RSpec.describe "V1::Embeddings", type: :request do
     end
   end
 
+  describe "POST /create with Idempotency-Key" do
+    before do
+      stub_const("V1::EmbeddingsController::IDEMPOTENCY_KEY_TTL", 60.seconds)
+      build_stub_request(
+        model: "text-embedding-ada-002",
+        input: [ "Hello, world!" ],
+        base64s: [ "AAAAPgAAgD4AAAA/" ],
+      )
+    end
+
+    def post_with_idempotency_key(key, input: "Hello, world!", headers: {})
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json",
+        "Idempotency-Key" => key
+      }.merge(headers), params: {
+        embedding: {
+          model: "text-embedding-ada-002",
+          input: input
+        }
+      }.to_json
+    end
+
+    it "replays the stored response and headers without calling upstream again" do
+      post_with_idempotency_key("retry-1")
+      first_body = response.body
+      expect(IdempotentResponse.count).to eq(1)
+      VectorCache.delete_all
+
+      post_with_idempotency_key("retry-1")
+
+      expect(response).to have_http_status(:ok)
+      expect(response.body).to eq(first_body)
+      expect(response.headers["X-Cachembed-Would-Have-Cost-Tokens"]).to eq("8")
+      expect(a_request(:post, "https://api.openai.com/v1/embeddings")).to have_been_made.once
+    end
+
+    it "processes requests with a different key independently" do
+      post_with_idempotency_key("retry-1")
+      VectorCache.delete_all
+
+      post_with_idempotency_key("retry-2")
+
+      expect(response).to have_http_status(:ok)
+      expect(a_request(:post, "https://api.openai.com/v1/embeddings")).to have_been_made.twice
+    end
+
+    it "returns 422 when the key is reused with a different body" do
+      post_with_idempotency_key("retry-1")
+      post_with_idempotency_key("retry-1", input: "Goodbye, world!")
+
+      expect(response).to have_http_status(:unprocessable_entity)
+      expect(JSON.parse(response.body)).to eq({ "errors" => [ "Idempotency-Key was already used with a different request body" ] })
+    end
+
+    it "processes the request again once the stored response has expired" do
+      post_with_idempotency_key("retry-1")
+      VectorCache.delete_all
+
+      travel 2.minutes do
+        post_with_idempotency_key("retry-1")
+      end
+
+      expect(response).to have_http_status(:ok)
+      expect(IdempotentResponse.count).to eq(1)
+      expect(a_request(:post, "https://api.openai.com/v1/embeddings")).to have_been_made.twice
+    end
+
+    it "does not share keys between tenants" do
+      stub_const("TenantPartitioning::TENANT_HEADER", "X-Tenant-Id")
+      post_with_idempotency_key("retry-1", headers: { "X-Tenant-Id" => "a" })
+      VectorCache.delete_all
+
+      post_with_idempotency_key("retry-1", headers: { "X-Tenant-Id" => "b" })
+
+      expect(response).to have_http_status(:ok)
+      expect(a_request(:post, "https://api.openai.com/v1/embeddings")).to have_been_made.twice
+    end
+  end
+
+  describe "POST /create cache key" do
+    before do
+      build_stub_request(
+        model: "text-embedding-ada-002",
+        input: [ "Hello, world!" ],
+        base64s: [ "AAAAPgAAgD4AAAA/" ],
+      )
+    end
+
+    def post_embedding(extra = {})
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }, params: {
+        embedding: {
+          model: "text-embedding-ada-002",
+          input: "Hello, world!"
+        }.merge(extra)
+      }.to_json
+    end
+
+    it "shares one stored vector between float and base64 requests" do
+      post_embedding(encoding_format: "float")
+      expect(JSON.parse(response.body)["data"].first["embedding"]).to eq([ 0.125, 0.25, 0.5 ])
+
+      post_embedding(encoding_format: "base64")
+      expect(JSON.parse(response.body)["data"].first["embedding"]).to eq("AAAAPgAAgD4AAAA/")
+
+      expect(VectorCache.count).to eq(1)
+      expect(a_request(:post, "https://api.openai.com/v1/embeddings")).to have_been_made.once
+    end
+
+    it "ignores fields that are not part of the cache key" do
+      post_embedding(user: "user-1")
+      post_embedding(user: "user-2")
+
+      expect(response).to be_successful
+      expect(VectorCache.count).to eq(1)
+      expect(a_request(:post, "https://api.openai.com/v1/embeddings")).to have_been_made.once
+    end
+  end
+
+  describe "POST /create with upstream encodings" do
+    def stub_upstream_embedding(embedding)
+      stub_request(:post, "https://api.openai.com/v1/embeddings")
+        .to_return(
+          status: 200,
+          headers: { "Content-Type" => "application/json" },
+          body: {
+            data: [ { embedding: embedding, index: 0, object: "embedding" } ],
+            model: "text-embedding-ada-002",
+            object: "list",
+            usage: { prompt_tokens: 8, total_tokens: 8 }
+          }.to_json
+        )
+    end
+
+    def post_embedding(encoding_format)
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }, params: {
+        embedding: {
+          model: "text-embedding-ada-002",
+          input: "Hello, world!",
+          encoding_format: encoding_format
+        }
+      }.to_json
+    end
+
+    it "returns base64 when requested even if upstream sent floats" do
+      stub_upstream_embedding([ 0.125, 0.25, 0.5 ])
+      post_embedding("base64")
+
+      expect(response).to be_successful
+      expect(JSON.parse(response.body)["data"].first["embedding"]).to eq("AAAAPgAAgD4AAAA/")
+    end
+
+    it "returns floats when requested and upstream sent base64" do
+      stub_upstream_embedding("AAAAPgAAgD4AAAA/")
+      post_embedding("float")
+
+      expect(response).to be_successful
+      expect(JSON.parse(response.body)["data"].first["embedding"]).to eq([ 0.125, 0.25, 0.5 ])
+    end
+
+    it "returns 502 when upstream data cannot be decoded" do
+      stub_upstream_embedding("not base64!")
+      post_embedding("float")
+
+      expect(response).to have_http_status(:bad_gateway)
+      expect(JSON.parse(response.body)["errors"]).to eq([ "Upstream returned an embedding that is not valid base64 at index 0" ])
+    end
+
+    context "with CACHEMBED_UPSTREAM_ENCODING_FORMAT=float" do
+      before { stub_const("UpstreamClient::ENCODING_FORMAT", "float") }
+
+      it "asks upstream for floats and stores the same vector as base64 would" do
+        stub_upstream_embedding([ 0.125, 0.25, 0.5 ])
+        post_embedding("base64")
+
+        expect(response).to be_successful
+        expect(JSON.parse(response.body)["data"].first["embedding"]).to eq("AAAAPgAAgD4AAAA/")
+        expect(a_request(:post, "https://api.openai.com/v1/embeddings").with(body: hash_including(encoding_format: "float"))).to have_been_made.once
+        expect(VectorCache.last.base64_content).to eq("AAAAPgAAgD4AAAA/")
+      end
+    end
+  end
+
+  describe "POST /create with short upstream data" do
+    before do
+      stub_request(:post, "https://api.openai.com/v1/embeddings")
+        .to_return(
+          status: 200,
+          headers: { "Content-Type" => "application/json" },
+          body: {
+            data: [ { embedding: "AAAAPgAAgD4AAAA/", index: 0, object: "embedding" } ],
+            model: "text-embedding-ada-002",
+            object: "list",
+            usage: { prompt_tokens: 8, total_tokens: 8 }
+          }.to_json
+        )
+    end
+
+    it "returns 502 without caching misaligned vectors" do
+      expect {
+        post v1_embeddings_path, headers: {
+          "Authorization" => "Bearer sk-abc123",
+          "Content-Type" => "application/json",
+          "Accept" => "application/json"
+        }, params: {
+          embedding: {
+            model: "text-embedding-ada-002",
+            input: [ "Hello, world!", "Goodbye, world!" ]
+          }
+        }.to_json
+      }.not_to change(VectorCache, :count)
+
+      expect(response).to have_http_status(:bad_gateway)
+      expect(JSON.parse(response.body)).to eq({ "errors" => [ "Upstream returned 1 embeddings for 2 inputs" ] })
+    end
+  end
+
+  describe "POST /create with upstream data out of index order" do
+    before do
+      stub_request(:post, "https://api.openai.com/v1/embeddings")
+        .to_return(
+          status: 200,
+          headers: { "Content-Type" => "application/json" },
+          body: {
+            data: [
+              { embedding: "AACAPgAAAD8AAAA+", index: 1, object: "embedding" },
+              { embedding: "AAAAPgAAgD4AAAA/", index: 0, object: "embedding" }
+            ],
+            model: "text-embedding-ada-002",
+            object: "list",
+            usage: { prompt_tokens: 8, total_tokens: 8 }
+          }.to_json
+        )
+    end
+
+    it "returns and caches the embeddings in input order" do
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }, params: {
+        embedding: {
+          model: "text-embedding-ada-002",
+          input: [ "Hello, world!", "Goodbye, world!" ]
+        }
+      }.to_json
+
+      expect(response).to be_successful
+      expect(JSON.parse(response.body)["data"]).to eq([
+        { "object" => "embedding", "embedding" => [ 0.125, 0.25, 0.5 ], "index" => 0 },
+        { "object" => "embedding", "embedding" => [ 0.25, 0.5, 0.125 ], "index" => 1 }
+      ])
+      expect(VectorCache.find_by(input_hash: Digest::SHA1.hexdigest("Goodbye, world!")).base64_content).to eq("AACAPgAAAD8AAAA+")
+    end
+  end
+
+  describe "POST /create with upstream error" do
+    before do
+      stub_request(:post, "https://api.openai.com/v1/embeddings")
+        .to_return(
+          status: 401,
+          headers: { "Content-Type" => "application/json" },
+          body: {
+            error: {
+              message: "Incorrect API key provided",
+              type: "invalid_request_error",
+              param: nil,
+              code: "invalid_api_key"
+            }
+          }.to_json
+        )
+    end
+
+    it "relays the upstream status and error body" do
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }, params: {
+        embedding: {
+          model: "text-embedding-ada-002",
+          input: "Hello, world!"
+        }
+      }.to_json
+
+      expect(response).to have_http_status(:unauthorized)
+      expect(JSON.parse(response.body)).to eq({
+        "error" => {
+          "message" => "Incorrect API key provided",
+          "type" => "invalid_request_error",
+          "param" => nil,
+          "code" => "invalid_api_key"
+        }
+      })
+    end
+  end
+
+  describe "POST /create with malformed request" do
+    def post_embedding(embedding)
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }, params: { embedding: embedding }.to_json
+    end
+
+    it "returns 400 when input is missing" do
+      post_embedding(model: "text-embedding-ada-002")
+
+      expect(response).to have_http_status(:bad_request)
+      expect(JSON.parse(response.body)).to eq({ "errors" => [ "input is required" ] })
+    end
+
+    it "returns 400 when input is an empty array" do
+      post_embedding(model: "text-embedding-ada-002", input: [])
+
+      expect(response).to have_http_status(:bad_request)
+      expect(JSON.parse(response.body)).to eq({ "errors" => [ "input must not be empty" ] })
+    end
+
+    it "returns 400 when input is an empty string" do
+      post_embedding(model: "text-embedding-ada-002", input: "")
+
+      expect(response).to have_http_status(:bad_request)
+      expect(JSON.parse(response.body)).to eq({ "errors" => [ "input must not be an empty string" ] })
+    end
+
+    it "returns 400 with the index of an empty element, before calling upstream" do
+      post_embedding(model: "text-embedding-ada-002", input: [ "Hello", "", "world" ])
+
+      expect(response).to have_http_status(:bad_request)
+      expect(JSON.parse(response.body)).to eq({ "errors" => [ "input at index 1 must not be empty" ] })
+      expect(a_request(:post, "https://api.openai.com/v1/embeddings")).not_to have_been_made
+      expect(EmbeddingRequest.count).to eq(0)
+    end
+
+    it "returns 400 when input is an object" do
+      post_embedding(model: "text-embedding-ada-002", input: { text: "hi" })
+
+      expect(response).to have_http_status(:bad_request)
+      expect(JSON.parse(response.body)["errors"].first).to start_with("Invalid input format")
+    end
+
+    it "returns 400 when input is nested deeper than the JSON parser allows" do
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }, params: %({"model":"text-embedding-ada-002","input":#{"[" * 1000}1#{"]" * 1000}})
+
+      expect(response).to have_http_status(:bad_request)
+      expect(a_request(:post, "https://api.openai.com/v1/embeddings")).not_to have_been_made
+    end
+
+    it "returns 422 when model is empty" do
+      post_embedding(model: "", input: "Hello, world!")
+
+      expect(response).to have_http_status(:unprocessable_entity)
+      expect(JSON.parse(response.body)["errors"]).to include("Model can't be blank")
+    end
+
+    it "returns 422 when dimensions is a string" do
+      post_embedding(model: "text-embedding-3-small", input: "Hello, world!", dimensions: "256")
+
+      expect(response).to have_http_status(:unprocessable_entity)
+      expect(JSON.parse(response.body)["errors"]).to include("Dimensions must be an integer, not string")
+    end
+
+    it "returns 422 when encoding_format is unknown" do
+      post_embedding(model: "text-embedding-ada-002", input: "Hello, world!", encoding_format: "binary")
+
+      expect(response).to have_http_status(:unprocessable_entity)
+      expect(JSON.parse(response.body)["errors"]).to include("Encoding format is not included in the list")
+    end
+  end
+
+  describe "POST /create with a gzipped body" do
+    before do
+      build_stub_request(
+        model: "text-embedding-ada-002",
+        input: [ "Hello, world!" ],
+        base64s: [ "AAAAPgAAgD4AAAA/" ],
+      )
+    end
+
+    it "inflates the body before parsing it" do
+      body = { embedding: { model: "text-embedding-ada-002", input: "Hello, world!" } }.to_json
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Content-Encoding" => "gzip",
+        "Accept" => "application/json"
+      }, params: ActiveSupport::Gzip.compress(body)
+
+      expect(response).to be_successful
+      expect(JSON.parse(response.body)["data"].first["embedding"]).to eq([ 0.125, 0.25, 0.5 ])
+    end
+  end
+
+  describe "POST to CACHEMBED_EMBEDDING_ENDPOINT_PATH" do
+    around do |example|
+      ENV["CACHEMBED_EMBEDDING_ENDPOINT_PATH"] = "/api/embed"
+      Rails.application.reload_routes!
+      example.run
+    ensure
+      ENV.delete("CACHEMBED_EMBEDDING_ENDPOINT_PATH")
+      Rails.application.reload_routes!
+    end
+
+    before do
+      build_stub_request(
+        model: "text-embedding-ada-002",
+        input: [ "Hello, world!" ],
+        base64s: [ "AAAAPgAAgD4AAAA/" ],
+      )
+    end
+
+    it "serves embeddings on the configured path as well" do
+      post "/api/embed", headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }, params: {
+        model: "text-embedding-ada-002",
+        input: "Hello, world!"
+      }.to_json
+
+      expect(response).to be_successful
+      expect(JSON.parse(response.body)["data"].first["embedding"]).to eq([ 0.125, 0.25, 0.5 ])
+    end
+  end
+
+  describe "other methods on /v1/embeddings" do
+    it "answers OPTIONS with 204 and the allowed methods" do
+      process :options, v1_embeddings_path
+
+      expect(response).to have_http_status(:no_content)
+      expect(response.headers["Allow"]).to eq("POST, OPTIONS")
+    end
+
+    it "returns 405 with the allowed methods for GET" do
+      get v1_embeddings_path
+
+      expect(response).to have_http_status(:method_not_allowed)
+      expect(response.headers["Allow"]).to eq("POST, OPTIONS")
+      expect(JSON.parse(response.body)).to eq({ "error" => { "message" => "Method not allowed", "type" => "invalid_request_error", "param" => nil, "code" => "method_not_allowed" } })
+    end
+
+    it "returns 405 for HEAD" do
+      head v1_embeddings_path
+
+      expect(response).to have_http_status(:method_not_allowed)
+      expect(response.headers["Allow"]).to eq("POST, OPTIONS")
+    end
+  end
+
+  describe "POST /create with CACHEMBED_TENANT_HEADER" do
+    before do
+      stub_const("TenantPartitioning::TENANT_HEADER", "X-Tenant-Id")
+      EmbeddingModel.create!(name: "text-embedding-ada-002", default_dimensions: 3)
+      VectorCache.create!(input_hash: Digest::SHA1.hexdigest("Hello, world!"), content: Base64.strict_decode64("AAAAPgAAgD4AAAA/"), model: "text-embedding-ada-002", dimensions: 3, tenant: "a")
+      build_stub_request(
+        model: "text-embedding-ada-002",
+        input: [ "Hello, world!" ],
+        base64s: [ "AAAAPwAAgD4AAAA+" ],
+      )
+    end
+
+    def post_embedding(headers)
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }.merge(headers), params: {
+        embedding: {
+          model: "text-embedding-ada-002",
+          input: "Hello, world!"
+        }
+      }.to_json
+    end
+
+    it "does not serve one tenant's entry to another" do
+      post_embedding("X-Tenant-Id" => "a")
+      expect(JSON.parse(response.body)["data"].first["embedding"]).to eq([ 0.125, 0.25, 0.5 ])
+
+      post_embedding("X-Tenant-Id" => "b")
+      expect(JSON.parse(response.body)["data"].first["embedding"]).to eq([ 0.5, 0.25, 0.125 ])
+      expect(VectorCache.order(:tenant).pluck(:tenant)).to eq([ "a", "b" ])
+    end
+
+    it "returns 400 without the header" do
+      post_embedding({})
+
+      expect(response).to have_http_status(:bad_request)
+      expect(JSON.parse(response.body)).to eq({ "errors" => [ "X-Tenant-Id header is required" ] })
+    end
+  end
+
+  describe "POST /create with CACHEMBED_CACHE_KEY_VERSION" do
+    before do
+      EmbeddingModel.create!(name: "text-embedding-ada-002", default_dimensions: 3)
+      VectorCache.create!(input_hash: Digest::SHA1.hexdigest("Hello, world!"), content: Base64.strict_decode64("AAAAPgAAgD4AAAA/"), model: "text-embedding-ada-002", dimensions: 3)
+      build_stub_request(
+        model: "text-embedding-ada-002",
+        input: [ "Hello, world!" ],
+        base64s: [ "AAAAPwAAgD4AAAA+" ],
+      )
+    end
+
+    it "misses entries stored under a previous version and keeps them" do
+      stub_const("VectorCache::KEY_VERSION", "2")
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }, params: {
+        embedding: {
+          model: "text-embedding-ada-002",
+          input: "Hello, world!"
+        }
+      }.to_json
+
+      expect(response).to be_successful
+      expect(JSON.parse(response.body)["data"].first["embedding"]).to eq([ 0.5, 0.25, 0.125 ])
+      expect(VectorCache.order(:key_version).pluck(:key_version)).to eq([ "", "2" ])
+    end
+  end
+
+  describe "POST /create X-Cachembed-Would-Have-Cost-Tokens" do
+    before do
+      EmbeddingModel.create!(name: "text-embedding-ada-002", default_dimensions: 3)
+      VectorCache.create!(input_hash: Digest::SHA1.hexdigest("Hello, world!"), content: Base64.strict_decode64("AAAAPgAAgD4AAAA/"), model: "text-embedding-ada-002", dimensions: 3, prompt_tokens: 5)
+      build_stub_request(
+        model: "text-embedding-ada-002",
+        input: [ "Goodbye, world!" ],
+        base64s: [ "AAAAPwAAgD4AAAA+" ],
+      )
+    end
+
+    it "adds the stored tokens of cached inputs to what upstream charged" do
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }, params: {
+        embedding: {
+          model: "text-embedding-ada-002",
+          input: [ "Hello, world!", "Goodbye, world!" ]
+        }
+      }.to_json
+
+      expect(response).to be_successful
+      expect(JSON.parse(response.body)["usage"]).to eq({ "prompt_tokens" => 8, "total_tokens" => 8 })
+      expect(response.headers["X-Cachembed-Would-Have-Cost-Tokens"]).to eq("13")
+    end
+  end
+
+  describe "POST /create with X-Cachembed-Expires-In" do
+    before do
+      build_stub_request(
+        model: "text-embedding-ada-002",
+        input: [ "Hello, world!" ],
+        base64s: [ "AAAAPgAAgD4AAAA/" ],
+      )
+    end
+
+    def post_embedding(headers = {})
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }.merge(headers), params: {
+        embedding: {
+          model: "text-embedding-ada-002",
+          input: "Hello, world!"
+        }
+      }.to_json
+    end
+
+    it "misses once the entry has expired and stores it again" do
+      freeze_time do
+        post_embedding("X-Cachembed-Expires-In" => "1h")
+        expect(VectorCache.last.expires_at).to eq(1.hour.from_now)
+      end
+
+      travel 30.minutes
+      post_embedding
+      expect(a_request(:post, "https://api.openai.com/v1/embeddings")).to have_been_made.once
+
+      travel 31.minutes
+      post_embedding
+      expect(response).to be_successful
+      expect(a_request(:post, "https://api.openai.com/v1/embeddings")).to have_been_made.twice
+      expect(VectorCache.count).to eq(1)
+      expect(VectorCache.last.expires_at).to be_nil
+    end
+
+    it "returns 422 for a malformed duration" do
+      post_embedding("X-Cachembed-Expires-In" => "soon")
+
+      expect(response).to have_http_status(:unprocessable_entity)
+      expect(JSON.parse(response.body)["errors"]).to eq([ "Expires in must be a positive duration such as 3600, 90m, 12h or 30d" ])
+    end
+  end
+
+  describe "POST /create with the cache database down" do
+    before do
+      allow(VectorCache).to receive(:where).and_raise(ActiveRecord::ConnectionNotEstablished)
+      allow(EmbeddingRequest).to receive(:insert_all!).and_raise(ActiveRecord::ConnectionNotEstablished)
+      allow(EmbeddingModel).to receive(:find_by).and_raise(ActiveRecord::ConnectionNotEstablished)
+      allow(EmbeddingModel).to receive(:find_or_create_by!).and_raise(ActiveRecord::ConnectionNotEstablished)
+      allow(VectorCache).to receive(:import_hashes!).and_raise(ActiveRecord::ConnectionNotEstablished)
+      build_stub_request(
+        model: "text-embedding-ada-002",
+        input: [ "Hello, world!" ],
+        base64s: [ "AAAAPgAAgD4AAAA/" ],
+      )
+    end
+
+    def post_embedding
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }, params: {
+        embedding: {
+          model: "text-embedding-ada-002",
+          input: "Hello, world!"
+        }
+      }.to_json
+    end
+
+    it "returns 503 without calling upstream when failing closed" do
+      stub_const("EmbeddingForm::DB_FAILURE_MODE", "fail-closed")
+      post_embedding
+
+      expect(response).to have_http_status(:service_unavailable)
+      expect(JSON.parse(response.body)).to eq({ "errors" => [ "Cache database is unavailable" ] })
+      expect(a_request(:post, "https://api.openai.com/v1/embeddings")).not_to have_been_made
+    end
+
+    it "proxies to upstream by default" do
+      post_embedding
+
+      expect(response).to be_successful
+      expect(JSON.parse(response.body)["data"].first["embedding"]).to eq([ 0.125, 0.25, 0.5 ])
+      expect(response.headers["X-Cachembed-Store-Error"]).to eq("true")
+    end
+  end
+
+  describe "POST /create with CACHEMBED_MAX_CACHED_INPUT_LENGTH" do
+    before do
+      stub_const("EmbeddingForm::MAX_CACHED_INPUT_LENGTH", 5)
+      build_stub_request(
+        model: "text-embedding-ada-002",
+        input: [ "Hello", "Hello, world!" ],
+        base64s: [ "AAAAPgAAgD4AAAA/", "AAAAPwAAgD4AAAA+" ],
+      )
+    end
+
+    it "returns every embedding but stores only the short inputs" do
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }, params: {
+        embedding: {
+          model: "text-embedding-ada-002",
+          input: [ "Hello", "Hello, world!" ]
+        }
+      }.to_json
+
+      expect(response).to be_successful
+      expect(JSON.parse(response.body)["data"].map { |item| item["embedding"] }).to eq([ [ 0.125, 0.25, 0.5 ], [ 0.5, 0.25, 0.125 ] ])
+      expect(VectorCache.pluck(:input_hash)).to eq([ Digest::SHA1.hexdigest("Hello") ])
+    end
+  end
+
+  describe "POST /create with CACHEMBED_CACHE_WRITE_ASYNC" do
+    before do
+      stub_const("EmbeddingForm::CACHE_WRITE_ASYNC", true)
+      build_stub_request(
+        model: "text-embedding-ada-002",
+        input: [ "Hello, world!" ],
+        base64s: [ "AAAAPgAAgD4AAAA/" ],
+      )
+    end
+
+    after { VectorCacheWriter.flush }
+
+    def post_embedding
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }, params: {
+        embedding: {
+          model: "text-embedding-ada-002",
+          input: "Hello, world!"
+        }
+      }.to_json
+    end
+
+    # a request arriving before the write lands may miss again; once flushed it hits
+    it "serves the stored vector once the background write has landed" do
+      post_embedding
+      expect(response).to be_successful
+      expect(JSON.parse(response.body)["data"].first["embedding"]).to eq([ 0.125, 0.25, 0.5 ])
+
+      VectorCacheWriter.flush
+      expect(VectorCache.count).to eq(1)
+
+      post_embedding
+      expect(JSON.parse(response.body)["data"].first["embedding"]).to eq([ 0.125, 0.25, 0.5 ])
+      expect(a_request(:post, "https://api.openai.com/v1/embeddings")).to have_been_made.once
+    end
+  end
+
+  describe "POST /create with dimensions" do
+    before do
+      stub_const("UpstreamClient::URL", "mock://deterministic?seed=spec")
+    end
+
+    def post_embedding
+      post v1_embeddings_path, headers: {
+        "Authorization" => "Bearer sk-abc123",
+        "Content-Type" => "application/json",
+        "Accept" => "application/json"
+      }, params: {
+        model: "text-embedding-3-small",
+        input: "Hello, world!",
+        dimensions: 256
+      }.to_json
+    end
+
+    it "sends dimensions upstream and stores them in the cache key" do
+      expect_any_instance_of(MockUpstream).to receive(:embed).with(hash_including(dimensions: 256)).once.and_call_original
+
+      post_embedding
+      expect(response).to be_successful
+      expect(JSON.parse(response.body)["data"].first["embedding"].size).to eq(256)
+      expect(VectorCache.pluck(:dimensions)).to eq([ 256 ])
+
+      post_embedding
+      expect(JSON.parse(response.body)["data"].first["embedding"].size).to eq(256)
+    end
+  end
+
   def build_stub_request(model:, input:, base64s:)
     upstream_response = {
       data: base64s.map.with_index do |base64, index|
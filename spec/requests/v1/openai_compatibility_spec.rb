@@ -0,0 +1,89 @@
+require 'rails_helper'
+require 'webmock/rspec'
+
+# The fixtures in spec/fixtures/files/openai are taken from the OpenAI API reference.
+# Responses must have the same field names and value types; key order and the
+# number of embeddings or dimensions may differ.
+RSpec.describe "OpenAI compatibility", type: :request do
+  let(:headers) do
+    {
+      "Authorization" => "Bearer sk-abc123",
+      "Content-Type" => "application/json",
+      "Accept" => "application/json"
+    }
+  end
+
+  before do
+    stub_const("UpstreamClient::URL", "mock://deterministic?dim=8&seed=spec")
+  end
+
+  {
+    "a single string" => [ "string.json", "float.json" ],
+    "an array of strings" => [ "string_array.json", "float.json" ],
+    "token arrays" => [ "token_arrays.json", "float.json" ],
+    "encoding_format base64" => [ "base64.json", "base64.json" ]
+  }.each do |description, (request_fixture, response_fixture)|
+    context "with #{description}" do
+      let(:request_body) { openai_fixture("requests/#{request_fixture}") }
+      let(:expected_shape) { json_shape(openai_fixture("responses/#{response_fixture}")) }
+
+      it "matches the response fixture when served by upstream" do
+        post v1_embeddings_path, headers: headers, params: request_body.to_json
+
+        expect(response).to have_http_status(:ok)
+        expect(json_shape(JSON.parse(response.body))).to eq(expected_shape)
+        expect(JSON.parse(response.body)["data"].size).to eq(Array.wrap(request_body["input"]).size)
+      end
+
+      it "matches the response fixture when served from the cache" do
+        post v1_embeddings_path, headers: headers, params: request_body.to_json
+        expect(MockUpstream).not_to receive(:new)
+        post v1_embeddings_path, headers: headers, params: request_body.to_json
+
+        expect(response).to have_http_status(:ok)
+        expect(json_shape(JSON.parse(response.body))).to eq(expected_shape)
+      end
+    end
+  end
+
+  context "when upstream returns an error" do
+    before do
+      stub_const("UpstreamClient::URL", "https://api.openai.com/v1/embeddings")
+      stub_request(:post, "https://api.openai.com/v1/embeddings").to_return(
+        status: 401,
+        body: file_fixture("openai/responses/error.json").read,
+        headers: { "Content-Type" => "application/json" }
+      )
+    end
+
+    it "matches the error fixture" do
+      post v1_embeddings_path, headers: headers, params: openai_fixture("requests/string.json").to_json
+
+      expect(response).to have_http_status(:unauthorized)
+      expect(json_shape(JSON.parse(response.body))).to eq(json_shape(openai_fixture("responses/error.json")))
+    end
+  end
+
+  it "returns 405 in the shape of the error fixture" do
+    get v1_embeddings_path, headers: headers
+
+    expect(response).to have_http_status(:method_not_allowed)
+    expect(json_shape(JSON.parse(response.body))).to eq(json_shape(openai_fixture("responses/error.json")))
+  end
+
+  def openai_fixture(name)
+    JSON.parse(file_fixture("openai/#{name}").read)
+  end
+
+  # replaces values with their JSON type, keeping field names; arrays keep the distinct shapes of their elements
+  def json_shape(value)
+    case value
+    when Hash then value.to_h { |key, item| [ key, json_shape(item) ] }
+    when Array then value.map { |item| json_shape(item) }.uniq
+    when Numeric then "number"
+    when String then "string"
+    when true, false then "boolean"
+    when nil then "null"
+    end
+  end
+end
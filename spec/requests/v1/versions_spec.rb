@@ -0,0 +1,38 @@
+require 'rails_helper'
+
+RSpec.describe "V1::Versions", type: :request do
+  describe "GET /v1/version" do
+    context "when an admin token is configured" do
+      before { stub_const("AdminTokenAuthentication::ADMIN_TOKEN", "admin-secret") }
+
+      it "returns the revision, adapter and schema version" do
+        stub_const("BuildInfo::REVISION", "0123abc")
+
+        get v1_version_path, headers: { "Authorization" => "Bearer admin-secret" }
+
+        expect(response).to be_successful
+        expect(JSON.parse(response.body)).to include(
+          "revision" => "0123abc",
+          "rails_version" => Rails.version,
+          "adapter" => ActiveRecord::Base.connection_db_config.adapter,
+          "schema_version" => ActiveRecord::Base.connection_pool.migration_context.current_version,
+          "migrations_current" => true
+        )
+      end
+
+      it "returns 401 for a wrong token" do
+        get v1_version_path, headers: { "Authorization" => "Bearer sk-abc123" }
+
+        expect(response).to have_http_status(:unauthorized)
+      end
+    end
+
+    context "when no admin token is configured" do
+      it "returns 404" do
+        get v1_version_path
+
+        expect(response).to have_http_status(:not_found)
+      end
+    end
+  end
+end